@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/petr-discover/cmd/models"
+)
+
+type AccessToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// AccessTokenOwner is what a presented token resolves to.
+type AccessTokenOwner struct {
+	TokenID  int64
+	Prefix   string
+	MemberID int64
+	Username string
+	Roles    []string
+	Scopes   []string
+}
+
+func (d *DB) CreateAccessToken(ctx context.Context, memberID int64, name, prefix, tokenHash string, scopes []string, ttl time.Duration) (AccessToken, error) {
+	token := AccessToken{Name: name, Prefix: prefix, Scopes: scopes}
+	err := d.QueryRowContext(ctx,
+		"INSERT INTO personal_access_token (member_id, name, token_prefix, token_hash, scopes, expires_at) "+
+			"VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP + make_interval(secs => $6)) RETURNING id, created_at, expires_at",
+		memberID, name, prefix, tokenHash, strings.Join(scopes, " "), ttl.Seconds()).
+		Scan(&token.ID, &token.CreatedAt, &token.ExpiresAt)
+	return token, err
+}
+
+// ListAccessTokens returns the member's tokens that have not been revoked,
+// including expired ones so the owner can see why a script stopped working.
+func (d *DB) ListAccessTokens(ctx context.Context, memberID int64) ([]AccessToken, error) {
+	rows, err := d.QueryContext(ctx,
+		"SELECT id, name, token_prefix, scopes, created_at, expires_at, last_used_at FROM personal_access_token "+
+			"WHERE member_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC",
+		memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []AccessToken{}
+	for rows.Next() {
+		var token AccessToken
+		var scopes string
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&token.ID, &token.Name, &token.Prefix, &scopes, &token.CreatedAt, &token.ExpiresAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Fields(scopes)
+		if lastUsed.Valid {
+			token.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (d *DB) RevokeAccessToken(ctx context.Context, memberID, tokenID int64) (bool, error) {
+	result, err := d.ExecContext(ctx,
+		"UPDATE personal_access_token SET revoked_at = CURRENT_TIMESTAMP "+
+			"WHERE id = $1 AND member_id = $2 AND revoked_at IS NULL",
+		tokenID, memberID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// LookupAccessToken resolves a live token by hash and records its use, at
+// most once per sessionTouchInterval. It returns nil for unknown, expired or
+// revoked tokens and for tokens of suspended members.
+func (d *DB) LookupAccessToken(ctx context.Context, tokenHash string) (*AccessTokenOwner, error) {
+	var owner AccessTokenOwner
+	var scopes, roles string
+	var stale bool
+	err := d.QueryRowContext(ctx,
+		"SELECT t.id, t.token_prefix, t.member_id, m.username, m.roles, t.scopes, "+
+			"t.last_used_at IS NULL OR t.last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2) "+
+			"FROM personal_access_token t JOIN member m ON m.id = t.member_id "+
+			"WHERE t.token_hash = $1 AND t.revoked_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP AND m.status = $3",
+		tokenHash, sessionTouchInterval.Seconds(), models.MemberActive).
+		Scan(&owner.TokenID, &owner.Prefix, &owner.MemberID, &owner.Username, &roles, &scopes, &stale)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	owner.Roles = strings.Fields(roles)
+	owner.Scopes = strings.Fields(scopes)
+
+	if stale {
+		_, err = d.ExecContext(ctx, "UPDATE personal_access_token SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1", owner.TokenID)
+		if err != nil {
+			return &owner, err
+		}
+	}
+	return &owner, nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type AuditEntry struct {
+	ID         int64                  `json:"id"`
+	ActorID    int64                  `json:"actor_id,omitempty"`
+	Actor      string                 `json:"actor"`
+	Action     string                 `json:"action"`
+	TargetType string                 `json:"target_type,omitempty"`
+	TargetID   string                 `json:"target_id,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	IP         string                 `json:"ip,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+type AuditFilter struct {
+	ActorID    int64
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     int64
+	Limit      int
+}
+
+func (d *DB) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	var details []byte
+	if len(entry.Details) > 0 {
+		var err error
+		details, err = json.Marshal(entry.Details)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := d.ExecContext(ctx,
+		"INSERT INTO audit_log (actor_id, actor, action, target_type, target_id, details, ip) "+
+			"VALUES (NULLIF($1, 0), $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, NULLIF($7, ''))",
+		entry.ActorID, entry.Actor, entry.Action, entry.TargetType, entry.TargetID, details, entry.IP)
+	return err
+}
+
+// ListAudit returns the newest entries matching filter; pass the last ID of
+// a page as Before to get the next one.
+func (d *DB) ListAudit(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	rows, err := d.QueryContext(ctx,
+		"SELECT id, COALESCE(actor_id, 0), actor, action, COALESCE(target_type, ''), COALESCE(target_id, ''), details, COALESCE(ip, ''), created_at "+
+			"FROM audit_log WHERE ($1 = 0 OR actor_id = $1) AND ($2 = '' OR action = $2) "+
+			"AND ($3 = '' OR target_type = $3) AND ($4 = '' OR target_id = $4) AND ($5 = 0 OR id < $5) "+
+			"ORDER BY id DESC LIMIT $6",
+		filter.ActorID, filter.Action, filter.TargetType, filter.TargetID, filter.Before, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var details []byte
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Actor, &entry.Action, &entry.TargetType, &entry.TargetID, &details, &entry.IP, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if details != nil {
+			if err := json.Unmarshal(details, &entry.Details); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
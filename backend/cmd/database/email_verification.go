@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrVerificationTokenInvalid = errors.New("verification token is invalid or expired")
+
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return "too many requests, retry after " + e.RetryAfter.Round(time.Second).String()
+}
+
+// CreateEmailVerificationToken stores tokenHash unless the previous token for
+// memberID was issued less than minInterval ago, in which case it returns a
+// *ThrottledError.
+func (d *DB) CreateEmailVerificationToken(ctx context.Context, memberID int64, tokenHash string, ttl, minInterval time.Duration) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the member row so concurrent resends are serialised.
+	_, err = tx.ExecContext(ctx, "SELECT id FROM member WHERE id = $1 FOR UPDATE", memberID)
+	if err != nil {
+		return err
+	}
+
+	var elapsed sql.NullFloat64
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - MAX(created_at)))::float8 FROM email_verification_token WHERE member_id = $1",
+		memberID).Scan(&elapsed)
+	if err != nil {
+		return err
+	}
+	if elapsed.Valid {
+		since := time.Duration(elapsed.Float64 * float64(time.Second))
+		if since < minInterval {
+			return &ThrottledError{RetryAfter: minInterval - since}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO email_verification_token (member_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		memberID, tokenHash, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DB) VerifyEmail(ctx context.Context, tokenHash string) (int64, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id, memberID int64
+	var expiresAt time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, member_id, expires_at FROM email_verification_token WHERE token_hash = $1 AND used_at IS NULL FOR UPDATE",
+		tokenHash).Scan(&id, &memberID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrVerificationTokenInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	if time.Now().After(expiresAt) {
+		return 0, ErrVerificationTokenInvalid
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE email_verification_token SET used_at = CURRENT_TIMESTAMP WHERE member_id = $1 AND used_at IS NULL", memberID)
+	if err != nil {
+		return 0, err
+	}
+	if err := markEmailVerified(ctx, tx, memberID); err != nil {
+		return 0, err
+	}
+	return memberID, tx.Commit()
+}
+
+func (d *DB) IsEmailVerified(ctx context.Context, memberID int64) (bool, error) {
+	var verified bool
+	err := d.QueryRowContext(ctx, "SELECT email_verified_at IS NOT NULL FROM member WHERE id = $1", memberID).Scan(&verified)
+	return verified, err
+}
+
+func (d *DB) MarkEmailVerified(ctx context.Context, memberID int64) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := markEmailVerified(ctx, tx, memberID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func markEmailVerified(ctx context.Context, tx *sql.Tx, memberID int64) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE member SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP) WHERE id = $1",
+		memberID)
+	return err
+}
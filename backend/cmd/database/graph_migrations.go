@@ -0,0 +1,76 @@
+package database
+
+var GraphMigrations = []GraphMigration{
+	{
+		Version: 1,
+		Name:    "initial_constraints",
+		Objects: []GraphSchemaObject{
+			{
+				Name:      "schema_migration_version",
+				Statement: "CREATE CONSTRAINT schema_migration_version IF NOT EXISTS FOR (m:SchemaMigration) REQUIRE m.version IS UNIQUE",
+			},
+			{
+				Name:      "user_username",
+				Statement: "CREATE CONSTRAINT user_username IF NOT EXISTS FOR (u:User) REQUIRE u.username IS UNIQUE",
+			},
+			{
+				Name:      "friend_request_sender_status",
+				Statement: "CREATE INDEX friend_request_sender_status IF NOT EXISTS FOR (r:FriendRequest) ON (r.sender, r.status)",
+			},
+			{
+				Name:      "friend_request_status",
+				Statement: "CREATE INDEX friend_request_status IF NOT EXISTS FOR (r:FriendRequest) ON (r.status)",
+			},
+			{
+				Name:      "card_name",
+				Statement: "CREATE INDEX card_name IF NOT EXISTS FOR (c:Card) ON (c.first_name, c.last_name)",
+			},
+		},
+	},
+	{
+		Version: 2,
+		Name:    "user_member_id",
+		Objects: []GraphSchemaObject{
+			{
+				Name:      "user_member_id",
+				Statement: "CREATE INDEX user_member_id IF NOT EXISTS FOR (u:User) ON (u.member_id)",
+			},
+		},
+	},
+	{
+		Version: 3,
+		Name:    "card_revisions",
+		Objects: []GraphSchemaObject{
+			{
+				Name:      "card_revision_id",
+				Statement: "CREATE CONSTRAINT card_revision_id IF NOT EXISTS FOR (rev:CardRevision) REQUIRE rev.id IS UNIQUE",
+			},
+			{
+				Name:      "card_revision_created_at",
+				Statement: "CREATE INDEX card_revision_created_at IF NOT EXISTS FOR (rev:CardRevision) ON (rev.created_at)",
+			},
+		},
+	},
+	{
+		Version: 4,
+		Name:    "card_suggestion_attributes",
+		Objects: []GraphSchemaObject{
+			{
+				Name:      "card_school",
+				Statement: "CREATE INDEX card_school IF NOT EXISTS FOR (c:Card) ON (c.school)",
+			},
+			{
+				Name:      "card_major",
+				Statement: "CREATE INDEX card_major IF NOT EXISTS FOR (c:Card) ON (c.major)",
+			},
+			{
+				Name:      "card_company",
+				Statement: "CREATE INDEX card_company IF NOT EXISTS FOR (c:Card) ON (c.company)",
+			},
+			{
+				Name:      "card_city",
+				Statement: "CREATE INDEX card_city IF NOT EXISTS FOR (c:Card) ON (c.city)",
+			},
+		},
+	},
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+type GraphSchemaObject struct {
+	Name      string
+	Statement string
+}
+
+type GraphMigration struct {
+	Version int64
+	Name    string
+	Objects []GraphSchemaObject
+}
+
+type GraphSchemaDrift struct {
+	Missing    []string
+	Unexpected []string
+}
+
+func (d GraphSchemaDrift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Unexpected) == 0
+}
+
+// EnsureGraphSchema applies pending graph migrations, recording each one as a
+// (:SchemaMigration) node, then re-creates any declared constraint or index
+// that has gone missing. Every statement uses IF NOT EXISTS, so it is safe to
+// run on each startup.
+func EnsureGraphSchema(ctx context.Context, driver neo4j.DriverWithContext, migrations []GraphMigration) (GraphSchemaDrift, error) {
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	applied, err := appliedGraphVersions(ctx, session)
+	if err != nil {
+		return GraphSchemaDrift{}, err
+	}
+
+	sorted := append([]GraphMigration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	for _, m := range sorted {
+		if applied[m.Version] {
+			continue
+		}
+		for _, object := range m.Objects {
+			if err := runSchemaStatement(ctx, session, object.Statement); err != nil {
+				return GraphSchemaDrift{}, fmt.Errorf("graph migration %d_%s failed on %s: %s", m.Version, m.Name, object.Name, err.Error())
+			}
+		}
+		_, err := session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+			_, err := transaction.Run(ctx,
+				"MERGE (m:SchemaMigration {version: $version}) SET m.name = $name, m.applied_at = datetime()",
+				map[string]any{
+					"version": m.Version,
+					"name":    m.Name,
+				})
+			return nil, err
+		})
+		if err != nil {
+			return GraphSchemaDrift{}, err
+		}
+		log.Printf("Applied graph migration %d_%s", m.Version, m.Name)
+	}
+
+	drift, err := GraphSchemaStatus(ctx, driver, migrations)
+	if err != nil {
+		return drift, err
+	}
+	declared := declaredGraphObjects(migrations)
+	for _, name := range drift.Missing {
+		log.Printf("Graph schema drift: %s is missing, re-creating", name)
+		if err := runSchemaStatement(ctx, session, declared[name].Statement); err != nil {
+			return drift, err
+		}
+	}
+	for _, name := range drift.Unexpected {
+		log.Printf("Graph schema drift: %s is not declared in GraphMigrations", name)
+	}
+	return drift, nil
+}
+
+// GraphSchemaStatus compares the constraints and indexes present in the
+// database with the ones declared by migrations.
+func GraphSchemaStatus(ctx context.Context, driver neo4j.DriverWithContext, migrations []GraphMigration) (GraphSchemaDrift, error) {
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(ctx)
+
+	present := map[string]bool{}
+	for _, query := range []string{
+		"SHOW CONSTRAINTS YIELD name RETURN name",
+		// Constraint-backed indexes are reported by both commands; the
+		// built-in token lookup indexes are not ours to manage.
+		"SHOW INDEXES YIELD name, type, owningConstraint WHERE type <> 'LOOKUP' AND owningConstraint IS NULL RETURN name",
+	} {
+		result, err := session.Run(ctx, query, nil)
+		if err != nil {
+			return GraphSchemaDrift{}, err
+		}
+		for result.Next(ctx) {
+			name, _ := result.Record().Get("name")
+			if s, ok := name.(string); ok {
+				present[s] = true
+			}
+		}
+		if err := result.Err(); err != nil {
+			return GraphSchemaDrift{}, err
+		}
+	}
+
+	var drift GraphSchemaDrift
+	declared := declaredGraphObjects(migrations)
+	for name := range declared {
+		if !present[name] {
+			drift.Missing = append(drift.Missing, name)
+		}
+	}
+	for name := range present {
+		if _, ok := declared[name]; !ok {
+			drift.Unexpected = append(drift.Unexpected, name)
+		}
+	}
+	sort.Strings(drift.Missing)
+	sort.Strings(drift.Unexpected)
+	return drift, nil
+}
+
+func declaredGraphObjects(migrations []GraphMigration) map[string]GraphSchemaObject {
+	declared := map[string]GraphSchemaObject{}
+	for _, m := range migrations {
+		for _, object := range m.Objects {
+			declared[object.Name] = object
+		}
+	}
+	return declared
+}
+
+func appliedGraphVersions(ctx context.Context, session neo4j.SessionWithContext) (map[int64]bool, error) {
+	result, err := session.Run(ctx, "MATCH (m:SchemaMigration) RETURN m.version AS version", nil)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int64]bool{}
+	for result.Next(ctx) {
+		version, _ := result.Record().Get("version")
+		if v, ok := version.(int64); ok {
+			applied[v] = true
+		}
+	}
+	return applied, result.Err()
+}
+
+// Schema commands cannot share a transaction with data writes, so they run
+// as auto-commit queries.
+func runSchemaStatement(ctx context.Context, session neo4j.SessionWithContext, statement string) error {
+	result, err := session.Run(ctx, statement, nil)
+	if err != nil {
+		return err
+	}
+	_, err = result.Consume(ctx)
+	return err
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdentityLinked = errors.New("identity is linked to another member")
+	ErrProviderLinked = errors.New("member already has an identity for this provider")
+)
+
+type Identity struct {
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// LoginIdentity resolves (provider, subject) to its member and records the
+// login. found is false when the identity has not been linked yet.
+func (d *DB) LoginIdentity(ctx context.Context, provider, subject, email string) (memberID int64, username string, found bool, err error) {
+	err = d.QueryRowContext(ctx,
+		"UPDATE member_identities i SET last_login_at = CURRENT_TIMESTAMP, email = $3 "+
+			"FROM member m WHERE m.id = i.member_id AND i.provider = $1 AND i.subject = $2 "+
+			"RETURNING m.id, m.username",
+		provider, subject, email).Scan(&memberID, &username)
+	if err == sql.ErrNoRows {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	return memberID, username, true, nil
+}
+
+func (d *DB) LinkIdentity(ctx context.Context, memberID int64, provider, subject, email string) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertIdentity(ctx, tx, memberID, provider, subject, email); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateMemberWithIdentity signs up a member whose only credential is an
+// external identity.
+func (d *DB) CreateMemberWithIdentity(ctx context.Context, username, hashedPassword, email string, emailVerified bool, provider, subject string) (int64, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertMember(ctx, tx, username, hashedPassword, email, emailVerified)
+	if err != nil {
+		return 0, err
+	}
+	if err := insertIdentity(ctx, tx, id, provider, subject, email); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func insertIdentity(ctx context.Context, tx *sql.Tx, memberID int64, provider, subject, email string) error {
+	var owner int64
+	err := tx.QueryRowContext(ctx,
+		"SELECT member_id FROM member_identities WHERE provider = $1 AND subject = $2",
+		provider, subject).Scan(&owner)
+	if err == nil {
+		if owner != memberID {
+			return ErrIdentityLinked
+		}
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM member_identities WHERE member_id = $1 AND provider = $2)",
+		memberID, provider).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrProviderLinked
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO member_identities (provider, subject, member_id, email, last_login_at) "+
+			"VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)",
+		provider, subject, memberID, email)
+	return err
+}
+
+func (d *DB) ListIdentities(ctx context.Context, memberID int64) ([]Identity, error) {
+	rows, err := d.QueryContext(ctx,
+		"SELECT provider, subject, COALESCE(email, ''), created_at, last_login_at FROM member_identities "+
+			"WHERE member_id = $1 ORDER BY created_at",
+		memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var identity Identity
+		var lastLogin sql.NullTime
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt, &lastLogin); err != nil {
+			return nil, err
+		}
+		if lastLogin.Valid {
+			identity.LastLoginAt = &lastLogin.Time
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// UnlinkIdentity removes the member's identity for provider unless it is the
+// only way left to sign in: a member without other identities needs a
+// verified email so the password can be reset.
+func (d *DB) UnlinkIdentity(ctx context.Context, memberID int64, provider string) (bool, error) {
+	result, err := d.ExecContext(ctx,
+		"DELETE FROM member_identities WHERE member_id = $1 AND provider = $2 AND ("+
+			"EXISTS (SELECT 1 FROM member_identities WHERE member_id = $1 AND provider <> $2) OR "+
+			"EXISTS (SELECT 1 FROM member WHERE id = $1 AND email_verified_at IS NOT NULL))",
+		memberID, provider)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (d *DB) UsernameTaken(ctx context.Context, username string) (bool, error) {
+	var taken bool
+	err := d.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM member WHERE username = $1)", username).Scan(&taken)
+	return taken, err
+}
+
+// MemberByEmail returns the member owning email and whether that address has
+// been verified, or found=false.
+func (d *DB) MemberByEmail(ctx context.Context, email string) (memberID int64, username string, verified bool, found bool, err error) {
+	err = d.QueryRowContext(ctx,
+		"SELECT id, username, email_verified_at IS NOT NULL FROM member WHERE lower(email) = lower($1)",
+		email).Scan(&memberID, &username, &verified)
+	if err == sql.ErrNoRows {
+		return 0, "", false, false, nil
+	}
+	if err != nil {
+		return 0, "", false, false, err
+	}
+	return memberID, username, verified, true, nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/petr-discover/internal"
+)
+
+// LoginAttemptStore is the Postgres internal.AttemptStore, shared by every
+// API instance. Times are stored as UTC.
+type LoginAttemptStore struct {
+	DB *DB
+}
+
+func (s LoginAttemptStore) Get(ctx context.Context, key string) (internal.AttemptState, error) {
+	return getAttemptState(s.DB.QueryRowContext(ctx,
+		"SELECT key, failures, last_failure_at, blocked_until, locked FROM login_attempt WHERE key = $1", key), key)
+}
+
+func (s LoginAttemptStore) Update(ctx context.Context, key string, fn func(*internal.AttemptState)) (internal.AttemptState, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return internal.AttemptState{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO login_attempt (key) VALUES ($1) ON CONFLICT (key) DO NOTHING", key)
+	if err != nil {
+		return internal.AttemptState{}, err
+	}
+	state, err := getAttemptState(tx.QueryRowContext(ctx,
+		"SELECT key, failures, last_failure_at, blocked_until, locked FROM login_attempt WHERE key = $1 FOR UPDATE", key), key)
+	if err != nil {
+		return internal.AttemptState{}, err
+	}
+
+	fn(&state)
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE login_attempt SET failures = $2, last_failure_at = $3, blocked_until = $4, locked = $5 WHERE key = $1",
+		key, state.Failures, nullTime(state.LastFailure), nullTime(state.BlockedUntil), state.Locked)
+	if err != nil {
+		return internal.AttemptState{}, err
+	}
+	return state, tx.Commit()
+}
+
+func (s LoginAttemptStore) Delete(ctx context.Context, key string) error {
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM login_attempt WHERE key = $1", key)
+	return err
+}
+
+// List also purges rows that have aged out, which keeps the table from
+// growing with one-off keys.
+func (s LoginAttemptStore) List(ctx context.Context, since time.Time) ([]internal.AttemptState, error) {
+	now := time.Now().UTC()
+	_, err := s.DB.ExecContext(ctx,
+		"DELETE FROM login_attempt WHERE (last_failure_at IS NULL OR last_failure_at <= $1) "+
+			"AND (blocked_until IS NULL OR blocked_until <= $2)",
+		since.UTC(), now)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx,
+		"SELECT key, failures, last_failure_at, blocked_until, locked FROM login_attempt ORDER BY last_failure_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []internal.AttemptState{}
+	for rows.Next() {
+		state, err := getAttemptState(rows, "")
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+func getAttemptState(row interface{ Scan(...interface{}) error }, key string) (internal.AttemptState, error) {
+	state := internal.AttemptState{Key: key}
+	var lastFailure, blockedUntil sql.NullTime
+	err := row.Scan(&state.Key, &state.Failures, &lastFailure, &blockedUntil, &state.Locked)
+	if err == sql.ErrNoRows {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if lastFailure.Valid {
+		state.LastFailure = lastFailure.Time.UTC()
+	}
+	if blockedUntil.Valid {
+		state.BlockedUntil = blockedUntil.Time.UTC()
+	}
+	return state, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
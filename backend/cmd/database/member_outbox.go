@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	MemberCreated = "member.created"
+	MemberUpdated = "member.updated"
+	MemberDeleted = "member.deleted"
+)
+
+type MemberEvent struct {
+	MemberID         int64  `json:"member_id"`
+	Username         string `json:"username"`
+	Email            string `json:"email,omitempty"`
+	PreviousUsername string `json:"previous_username,omitempty"`
+}
+
+type OutboxEntry struct {
+	ID        int64
+	EventType string
+	Event     MemberEvent
+	Attempts  int
+}
+
+func EnqueueMemberEvent(ctx context.Context, tx *sql.Tx, eventType string, event MemberEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO member_outbox (member_id, event_type, payload) VALUES ($1, $2, $3)",
+		event.MemberID, eventType, payload)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue %s: %s", eventType, err.Error())
+	}
+	return nil
+}
+
+// CreateMember inserts a member row and its member.created outbox entry in
+// one transaction so the graph projection can never miss a signup.
+func (d *DB) CreateMember(ctx context.Context, username, hashedPassword, email string, emailVerified bool) (int64, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertMember(ctx, tx, username, hashedPassword, email, emailVerified)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func insertMember(ctx context.Context, tx *sql.Tx, username, hashedPassword, email string, emailVerified bool) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx,
+		"INSERT INTO member (username, password, email, email_verified_at) "+
+			"VALUES ($1, $2, $3, CASE WHEN $4::boolean THEN CURRENT_TIMESTAMP END) RETURNING id",
+		username, hashedPassword, email, emailVerified).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert new user: %s", err.Error())
+	}
+
+	err = EnqueueMemberEvent(ctx, tx, MemberCreated, MemberEvent{MemberID: id, Username: username, Email: email})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (d *DB) UpdateMember(ctx context.Context, id int64, username, email string) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRowContext(ctx, "SELECT username FROM member WHERE id = $1 FOR UPDATE", id).Scan(&previous)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE member SET username = $1, email = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3",
+		username, email, id)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %s", err.Error())
+	}
+
+	err = EnqueueMemberEvent(ctx, tx, MemberUpdated, MemberEvent{MemberID: id, Username: username, Email: email, PreviousUsername: previous})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DB) DeleteMember(ctx context.Context, id int64) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var username string
+	err = tx.QueryRowContext(ctx, "DELETE FROM member WHERE id = $1 RETURNING username", id).Scan(&username)
+	if err != nil {
+		return err
+	}
+
+	err = EnqueueMemberEvent(ctx, tx, MemberDeleted, MemberEvent{MemberID: id, Username: username})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimOutbox locks up to limit unprocessed entries for the lifetime of tx.
+// SKIP LOCKED lets several workers drain the outbox without double delivery.
+// Once an entry is dead-lettered, later entries for the same member are held
+// back so they cannot apply out of order; they resume when it is retried or
+// marked processed by hand.
+func ClaimOutbox(ctx context.Context, tx *sql.Tx, limit, maxAttempts int) ([]OutboxEntry, error) {
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, event_type, payload, attempts FROM member_outbox o "+
+			"WHERE processed_at IS NULL AND attempts < $1 "+
+			"AND NOT EXISTS (SELECT 1 FROM member_outbox dead "+
+			"WHERE dead.member_id = o.member_id AND dead.id < o.id "+
+			"AND dead.processed_at IS NULL AND dead.attempts >= $1) "+
+			"ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED",
+		maxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		var payload []byte
+		if err := rows.Scan(&entry.ID, &entry.EventType, &payload, &entry.Attempts); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &entry.Event); err != nil {
+			return nil, fmt.Errorf("invalid outbox payload %d: %s", entry.ID, err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func MarkOutboxProcessed(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, "UPDATE member_outbox SET processed_at = CURRENT_TIMESTAMP, last_error = NULL WHERE id = $1", id)
+	return err
+}
+
+func MarkOutboxFailed(ctx context.Context, tx *sql.Tx, id int64, cause error) error {
+	_, err := tx.ExecContext(ctx, "UPDATE member_outbox SET attempts = attempts + 1, last_error = $1 WHERE id = $2", cause.Error(), id)
+	return err
+}
+
+func (d *DB) ListMemberUsernames(ctx context.Context) (map[string]int64, error) {
+	rows, err := d.QueryContext(ctx, "SELECT id, username FROM member")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := map[string]int64{}
+	for rows.Next() {
+		var id int64
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			return nil, err
+		}
+		members[username] = id
+	}
+	return members, rows.Err()
+}
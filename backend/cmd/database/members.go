@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/petr-discover/cmd/models"
+)
+
+type MemberSummary struct {
+	ID            int64      `json:"id"`
+	Username      string     `json:"username"`
+	Email         string     `json:"email"`
+	Roles         []string   `json:"roles"`
+	Status        string     `json:"status"`
+	EmailVerified bool       `json:"email_verified"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SuspendedAt   *time.Time `json:"suspended_at,omitempty"`
+}
+
+type MemberFilter struct {
+	Query  string
+	Status string
+	Limit  int
+	Offset int
+}
+
+const memberSummaryColumns = "id, username, email, roles, status, email_verified_at IS NOT NULL, created_at, suspended_at"
+
+func scanMemberSummary(row interface{ Scan(...interface{}) error }) (MemberSummary, error) {
+	var member MemberSummary
+	var roles string
+	var suspendedAt sql.NullTime
+	err := row.Scan(&member.ID, &member.Username, &member.Email, &roles, &member.Status,
+		&member.EmailVerified, &member.CreatedAt, &suspendedAt)
+	member.Roles = strings.Fields(roles)
+	if suspendedAt.Valid {
+		member.SuspendedAt = &suspendedAt.Time
+	}
+	return member, err
+}
+
+// SearchMembers matches Query against username and email and returns one
+// page plus the total number of matches.
+func (d *DB) SearchMembers(ctx context.Context, filter MemberFilter) ([]MemberSummary, int, error) {
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Query) + "%"
+	where := "WHERE (username ILIKE $1 OR email ILIKE $1) AND ($2 = '' OR status = $2)"
+
+	var total int
+	err := d.QueryRowContext(ctx, "SELECT count(*) FROM member "+where, pattern, filter.Status).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := d.QueryContext(ctx,
+		"SELECT "+memberSummaryColumns+" FROM member "+where+" ORDER BY id LIMIT $3 OFFSET $4",
+		pattern, filter.Status, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	members := []MemberSummary{}
+	for rows.Next() {
+		member, err := scanMemberSummary(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		members = append(members, member)
+	}
+	return members, total, rows.Err()
+}
+
+// GetMemberSummary returns nil when the member does not exist.
+func (d *DB) GetMemberSummary(ctx context.Context, id int64) (*MemberSummary, error) {
+	member, err := scanMemberSummary(d.QueryRowContext(ctx, "SELECT "+memberSummaryColumns+" FROM member WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// SuspendMember marks the member suspended and ends every session and
+// personal access token it holds. It reports false if the member does not
+// exist or is already suspended.
+func (d *DB) SuspendMember(ctx context.Context, id int64) (bool, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE member SET status = $1, suspended_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP "+
+			"WHERE id = $2 AND status <> $1",
+		models.MemberSuspended, id)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	for _, query := range []string{
+		"UPDATE refresh_token SET revoked_at = CURRENT_TIMESTAMP WHERE member_id = $1 AND revoked_at IS NULL",
+		"UPDATE auth_session SET revoked_at = CURRENT_TIMESTAMP WHERE member_id = $1 AND revoked_at IS NULL",
+		"UPDATE personal_access_token SET revoked_at = CURRENT_TIMESTAMP WHERE member_id = $1 AND revoked_at IS NULL",
+	} {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+func (d *DB) ReactivateMember(ctx context.Context, id int64) (bool, error) {
+	result, err := d.ExecContext(ctx,
+		"UPDATE member SET status = $1, suspended_at = NULL, updated_at = CURRENT_TIMESTAMP "+
+			"WHERE id = $2 AND status <> $1",
+		models.MemberActive, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (d *DB) SetMemberRoles(ctx context.Context, id int64, roles []string) (bool, error) {
+	result, err := d.ExecContext(ctx,
+		"UPDATE member SET roles = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+		strings.Join(roles, " "), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// MemberAccess returns what a login needs to know about username: its id,
+// roles and status. found is false when the member does not exist.
+func (d *DB) MemberAccess(ctx context.Context, username string) (id int64, roles []string, status string, found bool, err error) {
+	var rolesText string
+	err = d.QueryRowContext(ctx, "SELECT id, roles, status FROM member WHERE username = $1", username).
+		Scan(&id, &rolesText, &status)
+	if err == sql.ErrNoRows {
+		return 0, nil, "", false, nil
+	}
+	if err != nil {
+		return 0, nil, "", false, err
+	}
+	return id, strings.Fields(rolesText), status, true, nil
+}
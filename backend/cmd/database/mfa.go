@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+type MFAState struct {
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+func (d *DB) GetMFA(ctx context.Context, memberID int64) (*MFAState, error) {
+	var state MFAState
+	var confirmedAt sql.NullTime
+	err := d.QueryRowContext(ctx,
+		"SELECT secret, confirmed_at, last_used_step FROM member_mfa WHERE member_id = $1",
+		memberID).Scan(&state.Secret, &confirmedAt, &state.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state.Confirmed = confirmedAt.Valid
+	return &state, nil
+}
+
+// StartMFAEnrollment stores a new unconfirmed secret, replacing any earlier
+// unconfirmed one. It never overwrites a confirmed enrollment.
+func (d *DB) StartMFAEnrollment(ctx context.Context, memberID int64, secret string) (bool, error) {
+	result, err := d.ExecContext(ctx,
+		"INSERT INTO member_mfa (member_id, secret) VALUES ($1, $2) "+
+			"ON CONFLICT (member_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP "+
+			"WHERE member_mfa.confirmed_at IS NULL",
+		memberID, secret)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// ConfirmMFA marks the enrollment confirmed and replaces the recovery codes.
+func (d *DB) ConfirmMFA(ctx context.Context, memberID int64, step int64, recoveryCodeHashes []string) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE member_mfa SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $1 WHERE member_id = $2",
+		step, memberID)
+	if err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, memberID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records step as consumed. It fails when step is not newer than
+// the last accepted one, which is how replayed codes are rejected.
+func (d *DB) UseTOTPStep(ctx context.Context, memberID int64, step int64) (bool, error) {
+	result, err := d.ExecContext(ctx,
+		"UPDATE member_mfa SET last_used_step = $1 WHERE member_id = $2 AND last_used_step < $1",
+		step, memberID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (d *DB) UseRecoveryCode(ctx context.Context, memberID int64, codeHash string) (bool, error) {
+	result, err := d.ExecContext(ctx,
+		"UPDATE mfa_recovery_code SET used_at = CURRENT_TIMESTAMP "+
+			"WHERE id = (SELECT id FROM mfa_recovery_code WHERE member_id = $1 AND code_hash = $2 AND used_at IS NULL LIMIT 1)",
+		memberID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func (d *DB) RemainingRecoveryCodes(ctx context.Context, memberID int64) (int, error) {
+	var n int
+	err := d.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM mfa_recovery_code WHERE member_id = $1 AND used_at IS NULL",
+		memberID).Scan(&n)
+	return n, err
+}
+
+func (d *DB) RegenerateRecoveryCodes(ctx context.Context, memberID int64, recoveryCodeHashes []string) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, memberID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DB) DisableMFA(ctx context.Context, memberID int64) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_code WHERE member_id = $1", memberID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM member_mfa WHERE member_id = $1", memberID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, memberID int64, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_code WHERE member_id = $1", memberID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, "INSERT INTO mfa_recovery_code (member_id, code_hash) VALUES ($1, $2)", memberID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
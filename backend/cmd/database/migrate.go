@@ -0,0 +1,359 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrationLockID is the pg advisory lock key held while migrations run so
+// that concurrent goserver replicas apply them one at a time.
+const migrationLockID int64 = 7_426_318_001
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+func (d *DB) Migrate(ctx context.Context, migrations []Migration) error {
+	return d.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range sortedMigrations(migrations) {
+			if applied[m.Version] {
+				continue
+			}
+			if err := applyMigration(ctx, conn, m, true); err != nil {
+				return err
+			}
+			log.Printf("Applied migration %d_%s", m.Version, m.Name)
+		}
+		return nil
+	})
+}
+
+func (d *DB) Rollback(ctx context.Context, migrations []Migration, steps int) error {
+	return d.withMigrationLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		sorted := sortedMigrations(migrations)
+		for i := len(sorted) - 1; i >= 0 && steps > 0; i-- {
+			m := sorted[i]
+			if !applied[m.Version] {
+				continue
+			}
+			if err := applyMigration(ctx, conn, m, false); err != nil {
+				return err
+			}
+			log.Printf("Rolled back migration %d_%s", m.Version, m.Name)
+			steps--
+		}
+		return nil
+	})
+}
+
+func (d *DB) MigrationStatus(ctx context.Context, migrations []Migration) ([]MigrationStatus, error) {
+	conn, err := d.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := ensureMigrationTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	var status []MigrationStatus
+	for _, m := range sortedMigrations(migrations) {
+		status = append(status, MigrationStatus{Migration: m, Applied: applied[m.Version]})
+	}
+	return status, nil
+}
+
+func (d *DB) MigrateDryRun(ctx context.Context, w io.Writer, migrations []Migration) error {
+	status, err := d.MigrationStatus(ctx, migrations)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, s := range status {
+		if s.Applied {
+			continue
+		}
+		pending++
+		fmt.Fprintf(w, "-- %d_%s\n%s\n\n", s.Version, s.Name, strings.TrimSpace(s.Up))
+	}
+	if pending == 0 {
+		fmt.Fprintln(w, "-- no pending migrations")
+	}
+	return nil
+}
+
+// DiffModel compares the db-tagged fields of dbModel against
+// information_schema and returns the ALTER statements that would bring the
+// table in line with the struct. Nothing is executed.
+func (d *DB) DiffModel(ctx context.Context, dbModel interface{}) ([]string, error) {
+	table, columns, err := modelColumns(dbModel)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.QueryContext(ctx,
+		"SELECT column_name, data_type, character_maximum_length, is_nullable FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1",
+		table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := map[string]dbColumn{}
+	for rows.Next() {
+		var c dbColumn
+		var maxLen sql.NullInt64
+		var nullable string
+		if err := rows.Scan(&c.name, &c.dataType, &maxLen, &nullable); err != nil {
+			return nil, err
+		}
+		c.maxLength = maxLen.Int64
+		c.nullable = nullable == "YES"
+		existing[c.name] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(existing) == 0 {
+		var defs []string
+		for _, c := range columns {
+			defs = append(defs, c.definition())
+		}
+		return []string{fmt.Sprintf("CREATE TABLE %s (%s);", table, strings.Join(defs, ", "))}, nil
+	}
+
+	var statements []string
+	wanted := map[string]bool{}
+	for _, c := range columns {
+		wanted[c.name] = true
+		current, ok := existing[c.name]
+		if !ok {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", table, c.definition()))
+			continue
+		}
+		dataType, maxLength := normalizeType(c.dataType)
+		if dataType != "" && (dataType != current.dataType || (maxLength != 0 && maxLength != current.maxLength)) {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s;", table, c.name, c.dataType))
+		}
+		notNull := strings.Contains(strings.ToUpper(c.constraint), "NOT NULL") || strings.Contains(strings.ToUpper(c.dataType), "PRIMARY KEY")
+		if notNull && current.nullable {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", table, c.name))
+		} else if !notNull && !current.nullable {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;", table, c.name))
+		}
+	}
+
+	var extra []string
+	for name := range existing {
+		if !wanted[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, name))
+	}
+	return statements, nil
+}
+
+func (d *DB) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	// Advisory locks are held per session, so pin a single connection.
+	conn, err := d.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %s", err.Error())
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Printf("failed to release migration lock: %v", err)
+		}
+	}()
+
+	if err := ensureMigrationTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func ensureMigrationTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %s", err.Error())
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]bool{}
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statement := m.Up
+	if !up {
+		statement = m.Down
+	}
+	if strings.TrimSpace(statement) == "" {
+		return fmt.Errorf("migration %d_%s has no %s step", m.Version, m.Name, direction(up))
+	}
+	if _, err := tx.ExecContext(ctx, statement); err != nil {
+		return fmt.Errorf("migration %d_%s %s failed: %s", m.Version, m.Name, direction(up), err.Error())
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func direction(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
+
+func sortedMigrations(migrations []Migration) []Migration {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+type modelColumn struct {
+	name       string
+	dataType   string
+	constraint string
+}
+
+func (c modelColumn) definition() string {
+	return strings.TrimSpace(c.name + " " + c.dataType + " " + c.constraint)
+}
+
+type dbColumn struct {
+	name      string
+	dataType  string
+	maxLength int64
+	nullable  bool
+}
+
+func modelColumns(dbModel interface{}) (string, []modelColumn, error) {
+	t := reflect.TypeOf(dbModel)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return "", nil, fmt.Errorf("invalid model type %T", dbModel)
+	}
+
+	var columns []modelColumn
+	for i := 0; i < t.Elem().NumField(); i++ {
+		tags := t.Elem().Field(i).Tag
+		name := tags.Get("db")
+		if name == "" || name == "-" {
+			continue
+		}
+		columns = append(columns, modelColumn{
+			name:       name,
+			dataType:   tags.Get("dataType"),
+			constraint: tags.Get("constraint"),
+		})
+	}
+	return strings.ToLower(t.Elem().Name()), columns, nil
+}
+
+// normalizeType maps a dataType tag to the data_type and length reported by
+// information_schema. An empty result means the type is not compared.
+func normalizeType(dataType string) (string, int64) {
+	upper := strings.ToUpper(strings.TrimSpace(dataType))
+	if strings.HasSuffix(upper, "[]") {
+		return "ARRAY", 0
+	}
+	base := strings.Fields(upper)
+	if len(base) == 0 {
+		return "", 0
+	}
+	name := base[0]
+	var length int64
+	if open := strings.Index(name, "("); open >= 0 {
+		length, _ = strconv.ParseInt(strings.TrimSuffix(name[open+1:], ")"), 10, 64)
+		name = name[:open]
+	}
+	switch name {
+	case "SERIAL", "INT", "INTEGER":
+		return "integer", 0
+	case "BIGSERIAL", "BIGINT":
+		return "bigint", 0
+	case "VARCHAR":
+		return "character varying", length
+	case "TEXT":
+		return "text", 0
+	case "BOOLEAN", "BOOL":
+		return "boolean", 0
+	case "TIMESTAMP":
+		return "timestamp without time zone", 0
+	case "TIMESTAMPTZ":
+		return "timestamp with time zone", 0
+	case "JSONB":
+		return "jsonb", 0
+	case "UUID":
+		return "uuid", 0
+	}
+	return "", 0
+}
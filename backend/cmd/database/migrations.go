@@ -0,0 +1,205 @@
+package database
+
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_member",
+		Up: `CREATE TABLE IF NOT EXISTS member (
+			id SERIAL PRIMARY KEY NOT NULL,
+			username VARCHAR(50) NOT NULL UNIQUE,
+			password VARCHAR(255) NOT NULL,
+			email VARCHAR(50) NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);`,
+		Down: `DROP TABLE IF EXISTS member;`,
+	},
+	{
+		Version: 2,
+		Name:    "create_member_outbox",
+		Up: `CREATE TABLE IF NOT EXISTS member_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			member_id INTEGER NOT NULL,
+			event_type VARCHAR(50) NOT NULL,
+			payload JSONB NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			processed_at TIMESTAMP,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT
+		);
+		CREATE INDEX IF NOT EXISTS member_outbox_pending ON member_outbox (id) WHERE processed_at IS NULL;
+		INSERT INTO member_outbox (member_id, event_type, payload)
+			SELECT id, 'member.created', json_build_object('member_id', id, 'username', username, 'email', email) FROM member;`,
+		Down: `DROP TABLE IF EXISTS member_outbox;`,
+	},
+	{
+		Version: 3,
+		Name:    "create_refresh_token",
+		Up: `CREATE TABLE IF NOT EXISTS refresh_token (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			family_id UUID NOT NULL,
+			member_id INTEGER NOT NULL REFERENCES member (id) ON DELETE CASCADE,
+			issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS refresh_token_family ON refresh_token (family_id);
+		CREATE INDEX IF NOT EXISTS refresh_token_member ON refresh_token (member_id);`,
+		Down: `DROP TABLE IF EXISTS refresh_token;`,
+	},
+	{
+		Version: 4,
+		Name:    "create_auth_session",
+		Up: `CREATE TABLE IF NOT EXISTS auth_session (
+			id UUID PRIMARY KEY,
+			member_id INTEGER NOT NULL REFERENCES member (id) ON DELETE CASCADE,
+			user_agent VARCHAR(512) NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			revoked_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS auth_session_member ON auth_session (member_id);
+		INSERT INTO auth_session (id, member_id, created_at, last_used_at, revoked_at)
+			SELECT family_id, member_id, MIN(issued_at), MAX(issued_at),
+				CASE WHEN BOOL_AND(revoked_at IS NOT NULL) THEN MAX(revoked_at) END
+			FROM refresh_token GROUP BY family_id, member_id
+			ON CONFLICT (id) DO NOTHING;`,
+		Down: `DROP TABLE IF EXISTS auth_session;`,
+	},
+	{
+		Version: 5,
+		Name:    "create_password_reset_token",
+		Up: `CREATE TABLE IF NOT EXISTS password_reset_token (
+			id BIGSERIAL PRIMARY KEY,
+			member_id INTEGER NOT NULL REFERENCES member (id) ON DELETE CASCADE,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS password_reset_token_member ON password_reset_token (member_id);`,
+		Down: `DROP TABLE IF EXISTS password_reset_token;`,
+	},
+	{
+		Version: 6,
+		Name:    "email_verification",
+		Up: `ALTER TABLE member ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+		-- Members who signed up before verification existed are grandfathered.
+		UPDATE member SET email_verified_at = created_at WHERE email_verified_at IS NULL;
+		CREATE TABLE IF NOT EXISTS email_verification_token (
+			id BIGSERIAL PRIMARY KEY,
+			member_id INTEGER NOT NULL REFERENCES member (id) ON DELETE CASCADE,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS email_verification_token_member ON email_verification_token (member_id, created_at);`,
+		Down: `DROP TABLE IF EXISTS email_verification_token;
+		ALTER TABLE member DROP COLUMN IF EXISTS email_verified_at;`,
+	},
+	{
+		Version: 7,
+		Name:    "create_member_mfa",
+		Up: `CREATE TABLE IF NOT EXISTS member_mfa (
+			member_id INTEGER PRIMARY KEY REFERENCES member (id) ON DELETE CASCADE,
+			secret VARCHAR(64) NOT NULL,
+			confirmed_at TIMESTAMP,
+			last_used_step BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS mfa_recovery_code (
+			id BIGSERIAL PRIMARY KEY,
+			member_id INTEGER NOT NULL REFERENCES member (id) ON DELETE CASCADE,
+			code_hash CHAR(64) NOT NULL,
+			used_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS mfa_recovery_code_member ON mfa_recovery_code (member_id);`,
+		Down: `DROP TABLE IF EXISTS mfa_recovery_code;
+		DROP TABLE IF EXISTS member_mfa;`,
+	},
+	{
+		Version: 8,
+		Name:    "create_member_identities",
+		Up: `CREATE TABLE IF NOT EXISTS member_identities (
+			provider VARCHAR(50) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			member_id INTEGER NOT NULL REFERENCES member (id) ON DELETE CASCADE,
+			email VARCHAR(255),
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_login_at TIMESTAMP,
+			PRIMARY KEY (provider, subject),
+			UNIQUE (member_id, provider)
+		);`,
+		Down: `DROP TABLE IF EXISTS member_identities;`,
+	},
+	{
+		Version: 9,
+		Name:    "create_personal_access_token",
+		Up: `CREATE TABLE IF NOT EXISTS personal_access_token (
+			id BIGSERIAL PRIMARY KEY,
+			member_id INTEGER NOT NULL REFERENCES member (id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			token_prefix VARCHAR(16) NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			scopes VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS personal_access_token_member ON personal_access_token (member_id);`,
+		Down: `DROP TABLE IF EXISTS personal_access_token;`,
+	},
+	{
+		Version: 10,
+		Name:    "member_roles_and_audit_log",
+		Up: `ALTER TABLE member ADD COLUMN IF NOT EXISTS roles VARCHAR(255) NOT NULL DEFAULT 'user';
+		ALTER TABLE member ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active';
+		ALTER TABLE member ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP;
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			actor_id INTEGER,
+			actor VARCHAR(50) NOT NULL,
+			action VARCHAR(50) NOT NULL,
+			target_type VARCHAR(50),
+			target_id VARCHAR(100),
+			details JSONB,
+			ip VARCHAR(64),
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS audit_log_created ON audit_log (created_at);
+		CREATE INDEX IF NOT EXISTS audit_log_target ON audit_log (target_type, target_id);`,
+		Down: `DROP TABLE IF EXISTS audit_log;
+		ALTER TABLE member DROP COLUMN IF EXISTS suspended_at;
+		ALTER TABLE member DROP COLUMN IF EXISTS status;
+		ALTER TABLE member DROP COLUMN IF EXISTS roles;`,
+	},
+	{
+		Version: 11,
+		Name:    "create_login_attempt",
+		Up: `CREATE TABLE IF NOT EXISTS login_attempt (
+			key VARCHAR(320) PRIMARY KEY,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure_at TIMESTAMP,
+			blocked_until TIMESTAMP,
+			locked BOOLEAN NOT NULL DEFAULT FALSE
+		);
+		CREATE INDEX IF NOT EXISTS login_attempt_last_failure ON login_attempt (last_failure_at);`,
+		Down: `DROP TABLE IF EXISTS login_attempt;`,
+	},
+	{
+		Version: 12,
+		Name:    "member_outbox_member_index",
+		Up:      `CREATE INDEX IF NOT EXISTS member_outbox_member_pending ON member_outbox (member_id, id) WHERE processed_at IS NULL;`,
+		Down:    `DROP INDEX IF EXISTS member_outbox_member_pending;`,
+	},
+	{
+		Version: 13,
+		Name:    "refresh_token_replaced_by",
+		Up:      `ALTER TABLE refresh_token ADD COLUMN IF NOT EXISTS replaced_by UUID;`,
+		Down:    `ALTER TABLE refresh_token DROP COLUMN IF EXISTS replaced_by;`,
+	},
+}
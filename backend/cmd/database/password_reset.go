@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrResetTokenInvalid = errors.New("reset token is invalid or expired")
+
+// CreatePasswordResetToken stores tokenHash for memberID and invalidates any
+// reset token issued earlier, so only the most recent email works.
+func (d *DB) CreatePasswordResetToken(ctx context.Context, memberID int64, tokenHash string, ttl time.Duration) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE password_reset_token SET used_at = CURRENT_TIMESTAMP WHERE member_id = $1 AND used_at IS NULL",
+		memberID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO password_reset_token (member_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		memberID, tokenHash, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetPassword consumes the reset token, stores the new password hash and
+// revokes every session of the member in one transaction, returning the
+// member whose password changed.
+func (d *DB) ResetPassword(ctx context.Context, tokenHash, hashedPassword string) (int64, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id, memberID int64
+	var expiresAt time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, member_id, expires_at FROM password_reset_token WHERE token_hash = $1 AND used_at IS NULL FOR UPDATE",
+		tokenHash).Scan(&id, &memberID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrResetTokenInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	if time.Now().After(expiresAt) {
+		return 0, ErrResetTokenInvalid
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE password_reset_token SET used_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE member SET password = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+		hashedPassword, memberID)
+	if err != nil {
+		return 0, err
+	}
+	if err := revokeOtherSessions(ctx, tx, memberID, ""); err != nil {
+		return 0, err
+	}
+	return memberID, tx.Commit()
+}
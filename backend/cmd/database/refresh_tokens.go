@@ -0,0 +1,153 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+type RefreshToken struct {
+	ID       string
+	FamilyID string
+	MemberID int64
+}
+
+// IssueRefreshToken starts a new token family, i.e. a new login session.
+func (d *DB) IssueRefreshToken(ctx context.Context, memberID int64, userAgent, ip string, ttl time.Duration) (RefreshToken, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	token := RefreshToken{MemberID: memberID}
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO refresh_token (family_id, member_id, expires_at) VALUES (gen_random_uuid(), $1, $2) RETURNING id, family_id",
+		memberID, time.Now().Add(ttl)).Scan(&token.ID, &token.FamilyID)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO auth_session (id, member_id, user_agent, ip) VALUES ($1, $2, $3, $4)",
+		token.FamilyID, memberID, truncate(userAgent, 512), truncate(ip, 64))
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return token, tx.Commit()
+}
+
+// RotateRefreshToken consumes tokenID and returns its successor in the same
+// family. Presenting a token that was already used or revoked means it
+// leaked, so the whole family is revoked and ErrRefreshTokenReused returned.
+// The exception is a token used less than grace ago whose successor is still
+// unused: parallel requests that raced on the same cookie get that successor
+// back instead of logging the user out.
+func (d *DB) RotateRefreshToken(ctx context.Context, tokenID string, ttl, grace time.Duration) (RefreshToken, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	current := RefreshToken{ID: tokenID}
+	var usedAt, revokedAt sql.NullTime
+	var replacedBy sql.NullString
+	var expiresAt time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT family_id, member_id, expires_at, used_at, revoked_at, replaced_by FROM refresh_token WHERE id = $1 FOR UPDATE",
+		tokenID).Scan(&current.FamilyID, &current.MemberID, &expiresAt, &usedAt, &revokedAt, &replacedBy)
+	if err == sql.ErrNoRows {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	if usedAt.Valid && !revokedAt.Valid && replacedBy.Valid && time.Since(usedAt.Time) < grace {
+		successor := RefreshToken{ID: replacedBy.String, FamilyID: current.FamilyID, MemberID: current.MemberID}
+		var live bool
+		err = tx.QueryRowContext(ctx,
+			"SELECT used_at IS NULL AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP FROM refresh_token WHERE id = $1",
+			successor.ID).Scan(&live)
+		if err != nil && err != sql.ErrNoRows {
+			return RefreshToken{}, err
+		}
+		if live {
+			return successor, nil
+		}
+	}
+	if usedAt.Valid || revokedAt.Valid {
+		if err := revokeFamily(ctx, tx, current.FamilyID); err != nil {
+			return RefreshToken{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return RefreshToken{}, err
+		}
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+	if time.Now().After(expiresAt) {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+
+	next := RefreshToken{FamilyID: current.FamilyID, MemberID: current.MemberID}
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO refresh_token (family_id, member_id, expires_at) VALUES ($1, $2, $3) RETURNING id",
+		current.FamilyID, current.MemberID, time.Now().Add(ttl)).Scan(&next.ID)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_token SET used_at = CURRENT_TIMESTAMP, replaced_by = $1 WHERE id = $2", next.ID, tokenID)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE auth_session SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1", current.FamilyID)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return next, tx.Commit()
+}
+
+func (d *DB) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeFamily(ctx, tx, familyID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *DB) RevokeMemberRefreshTokens(ctx context.Context, memberID int64) error {
+	return d.RevokeOtherSessions(ctx, memberID, "")
+}
+
+func revokeFamily(ctx context.Context, tx *sql.Tx, familyID string) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE refresh_token SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = $1 AND revoked_at IS NULL",
+		familyID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE auth_session SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL",
+		familyID)
+	return err
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// sessionTouchInterval bounds how often an authenticated request rewrites
+// last_used_at.
+const sessionTouchInterval = time.Minute
+
+func (d *DB) ListSessions(ctx context.Context, memberID int64) ([]Session, error) {
+	rows, err := d.QueryContext(ctx,
+		"SELECT s.id, s.user_agent, s.ip, s.created_at, s.last_used_at FROM auth_session s "+
+			"WHERE s.member_id = $1 AND s.revoked_at IS NULL "+
+			"AND EXISTS (SELECT 1 FROM refresh_token t WHERE t.family_id = s.id AND t.revoked_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP) "+
+			"ORDER BY s.last_used_at DESC",
+		memberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes one of memberID's sessions and reports whether it
+// existed.
+func (d *DB) RevokeSession(ctx context.Context, memberID int64, sessionID string) (bool, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var owner int64
+	err = tx.QueryRowContext(ctx, "SELECT member_id FROM auth_session WHERE id::text = $1", sessionID).Scan(&owner)
+	if err == sql.ErrNoRows || (err == nil && owner != memberID) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := revokeFamily(ctx, tx, sessionID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RevokeOtherSessions revokes every session of memberID except keepSessionID.
+// An empty keepSessionID revokes them all.
+func (d *DB) RevokeOtherSessions(ctx context.Context, memberID int64, keepSessionID string) error {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeOtherSessions(ctx, tx, memberID, keepSessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func revokeOtherSessions(ctx context.Context, tx *sql.Tx, memberID int64, keepSessionID string) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE refresh_token SET revoked_at = CURRENT_TIMESTAMP "+
+			"WHERE member_id = $1 AND revoked_at IS NULL AND family_id::text <> $2",
+		memberID, keepSessionID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE auth_session SET revoked_at = CURRENT_TIMESTAMP "+
+			"WHERE member_id = $1 AND revoked_at IS NULL AND id::text <> $2",
+		memberID, keepSessionID)
+	return err
+}
+
+// TouchSession reports whether sessionID is still active and records the
+// access.
+func (d *DB) TouchSession(ctx context.Context, sessionID string) (bool, error) {
+	var revoked, stale bool
+	err := d.QueryRowContext(ctx,
+		"SELECT revoked_at IS NOT NULL, last_used_at < CURRENT_TIMESTAMP - make_interval(secs => $2) FROM auth_session WHERE id = $1",
+		sessionID, sessionTouchInterval.Seconds()).Scan(&revoked, &stale)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if revoked {
+		return false, nil
+	}
+	if stale {
+		_, err = d.ExecContext(ctx, "UPDATE auth_session SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1", sessionID)
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package graphsync
+
+import (
+	"context"
+	"sort"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+)
+
+type ReconcileReport struct {
+	MissingNodes    []string `json:"missing_nodes"`
+	OrphanNodes     []string `json:"orphan_nodes"`
+	MismatchedNodes []string `json:"mismatched_nodes"`
+}
+
+// Reconcile compares member rows with (:User) nodes. Members without a node
+// and nodes whose member_id is stale are re-projected; nodes with no member
+// row are deleted together with their card and friend requests. Nothing is
+// written unless fix is true.
+func Reconcile(ctx context.Context, db *database.DB, driver neo4j.DriverWithContext, fix bool) (ReconcileReport, error) {
+	var report ReconcileReport
+
+	members, err := db.ListMemberUsernames(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	result, err := session.Run(ctx, "MATCH (u:User) RETURN u.username AS username, u.member_id AS member_id", nil)
+	if err != nil {
+		return report, err
+	}
+	nodes := map[string]any{}
+	for result.Next(ctx) {
+		record := result.Record()
+		username, _ := record.Get("username")
+		memberID, _ := record.Get("member_id")
+		if name, ok := username.(string); ok {
+			nodes[name] = memberID
+		}
+	}
+	if err := result.Err(); err != nil {
+		return report, err
+	}
+
+	for username, id := range members {
+		memberID, ok := nodes[username]
+		if !ok {
+			report.MissingNodes = append(report.MissingNodes, username)
+		} else if memberID != id {
+			report.MismatchedNodes = append(report.MismatchedNodes, username)
+		}
+	}
+	for username := range nodes {
+		if _, ok := members[username]; !ok {
+			report.OrphanNodes = append(report.OrphanNodes, username)
+		}
+	}
+	sort.Strings(report.MissingNodes)
+	sort.Strings(report.OrphanNodes)
+	sort.Strings(report.MismatchedNodes)
+
+	if !fix {
+		return report, nil
+	}
+
+	_, err = session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+		for _, username := range append(append([]string{}, report.MissingNodes...), report.MismatchedNodes...) {
+			if err := upsertUser(ctx, transaction, database.MemberEvent{MemberID: members[username], Username: username}); err != nil {
+				return nil, err
+			}
+		}
+		for _, username := range report.OrphanNodes {
+			if err := deleteUser(ctx, transaction, -1, username); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return report, err
+}
@@ -0,0 +1,154 @@
+package graphsync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/config"
+)
+
+// Worker projects member_outbox entries into (:User) nodes. Every projection
+// is idempotent, so an entry that is delivered twice after a crash between
+// the Neo4j write and the outbox commit does no harm.
+type Worker struct {
+	DB     *database.DB
+	Driver neo4j.DriverWithContext
+	Config *config.SyncConfig
+}
+
+func NewWorker(db *database.DB, driver neo4j.DriverWithContext) *Worker {
+	return &Worker{DB: db, Driver: driver, Config: config.MemberSyncConfig()}
+}
+
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Config.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := w.ProcessBatch(ctx)
+			if err != nil {
+				log.Printf("member sync: %v", err)
+				break
+			}
+			if n < w.Config.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) ProcessBatch(ctx context.Context) (int, error) {
+	tx, err := w.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	entries, err := database.ClaimOutbox(ctx, tx, w.Config.BatchSize, w.Config.MaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	// Events for one member must apply in order, so once one fails the rest
+	// of that member's events wait for the next round.
+	failed := map[int64]bool{}
+	for _, entry := range entries {
+		if failed[entry.Event.MemberID] {
+			continue
+		}
+		if err := w.project(ctx, entry); err != nil {
+			failed[entry.Event.MemberID] = true
+			log.Printf("member sync: entry %d (%s) failed: %v", entry.ID, entry.EventType, err)
+			if entry.Attempts+1 >= w.Config.MaxAttempts {
+				log.Printf("member sync: entry %d dead-lettered; holding later events for member %d", entry.ID, entry.Event.MemberID)
+			}
+			if err := database.MarkOutboxFailed(ctx, tx, entry.ID, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := database.MarkOutboxProcessed(ctx, tx, entry.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), tx.Commit()
+}
+
+func (w *Worker) project(ctx context.Context, entry database.OutboxEntry) error {
+	session := w.Driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	_, err := session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (any, error) {
+		switch entry.EventType {
+		case database.MemberCreated, database.MemberUpdated:
+			return nil, upsertUser(ctx, transaction, entry.Event)
+		case database.MemberDeleted:
+			return nil, deleteUser(ctx, transaction, entry.Event.MemberID, entry.Event.Username)
+		}
+		return nil, fmt.Errorf("unknown event type %s", entry.EventType)
+	})
+	return err
+}
+
+func upsertUser(ctx context.Context, transaction neo4j.ManagedTransaction, event database.MemberEvent) error {
+	// Nodes created before the outbox existed have no member_id yet, so fall
+	// back to the previous username before creating a fresh node.
+	lookup := event.PreviousUsername
+	if lookup == "" {
+		lookup = event.Username
+	}
+	result, err := transaction.Run(ctx,
+		"OPTIONAL MATCH (byID:User {member_id: $member_id}) "+
+			"OPTIONAL MATCH (byName:User {username: $lookup}) "+
+			"WITH coalesce(byID, byName) AS u "+
+			"WHERE u IS NOT NULL "+
+			"SET u.username = $username, u.member_id = $member_id "+
+			"RETURN u",
+		map[string]any{
+			"member_id": event.MemberID,
+			"username":  event.Username,
+			"lookup":    lookup,
+		})
+	if err != nil {
+		return err
+	}
+	if result.Next(ctx) {
+		return nil
+	}
+	if err := result.Err(); err != nil {
+		return err
+	}
+
+	_, err = transaction.Run(ctx,
+		"MERGE (u:User {username: $username}) SET u.member_id = $member_id",
+		map[string]any{
+			"member_id": event.MemberID,
+			"username":  event.Username,
+		})
+	return err
+}
+
+func deleteUser(ctx context.Context, transaction neo4j.ManagedTransaction, memberID int64, username string) error {
+	_, err := transaction.Run(ctx,
+		"MATCH (u:User) WHERE u.member_id = $member_id OR u.username = $username "+
+			"CALL { WITH u MATCH (u)-[:HAS_CARD]->(c:Card) DETACH DELETE c } "+
+			"CALL { WITH u MATCH (u)-[:SENT_FRIEND_REQUEST|TO_USER]-(fr:FriendRequest) DETACH DELETE fr } "+
+			"CALL { WITH u MATCH (u)-[:HAS_CARD_REVISION]->(rev:CardRevision) DETACH DELETE rev } "+
+			"DETACH DELETE u",
+		map[string]any{
+			"member_id": memberID,
+			"username":  username,
+		})
+	return err
+}
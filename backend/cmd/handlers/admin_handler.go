@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/cmd/models"
+)
+
+const (
+	defaultAdminPageSize = 50
+	maxAdminPageSize     = 200
+)
+
+type SuspendMemberRequest struct {
+	Reason string `json:"reason"`
+}
+
+type SetRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+func AdminListMembers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := database.MemberFilter{
+		Query:  query.Get("q"),
+		Status: query.Get("status"),
+		Limit:  queryInt(r, "limit", defaultAdminPageSize),
+		Offset: queryInt(r, "offset", 0),
+	}
+	if filter.Limit < 1 || filter.Limit > maxAdminPageSize || filter.Offset < 0 {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid limit or offset")
+		return
+	}
+	if filter.Status != "" && filter.Status != models.MemberActive && filter.Status != models.MemberSuspended {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid status filter")
+		return
+	}
+
+	members, total, err := database.DBMain.SearchMembers(r.Context(), filter)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to list members")
+		return
+	}
+	recordAudit(r, "members.list", "", "", map[string]interface{}{"q": filter.Query, "status": filter.Status})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"members": members, "total": total})
+}
+
+func AdminGetMember(w http.ResponseWriter, r *http.Request) {
+	member, ok := adminMember(w, r)
+	if !ok {
+		return
+	}
+	recordAudit(r, "members.view", "member", strconv.FormatInt(member.ID, 10), nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(member)
+}
+
+func AdminSuspendMember(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	member, ok := adminMember(w, r)
+	if !ok {
+		return
+	}
+	var suspendRequest SuspendMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&suspendRequest); err != nil && r.ContentLength != 0 {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if member.ID == principal.MemberID {
+		writeJSONMessage(w, http.StatusBadRequest, "You cannot suspend yourself")
+		return
+	}
+	if containsString(member.Roles, RoleAdmin) && !principal.HasRole(RoleAdmin) {
+		writeJSONMessage(w, http.StatusForbidden, "Only admins can suspend admins")
+		return
+	}
+
+	changed, err := database.DBMain.SuspendMember(r.Context(), member.ID)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to suspend member")
+		return
+	}
+	if !changed {
+		writeJSONMessage(w, http.StatusConflict, "Member is already suspended")
+		return
+	}
+	recordAudit(r, "members.suspend", "member", strconv.FormatInt(member.ID, 10),
+		map[string]interface{}{"username": member.Username, "reason": suspendRequest.Reason})
+	writeJSONMessage(w, http.StatusOK, "Member suspended")
+}
+
+func AdminReactivateMember(w http.ResponseWriter, r *http.Request) {
+	member, ok := adminMember(w, r)
+	if !ok {
+		return
+	}
+
+	changed, err := database.DBMain.ReactivateMember(r.Context(), member.ID)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to reactivate member")
+		return
+	}
+	if !changed {
+		writeJSONMessage(w, http.StatusConflict, "Member is already active")
+		return
+	}
+	recordAudit(r, "members.reactivate", "member", strconv.FormatInt(member.ID, 10),
+		map[string]interface{}{"username": member.Username})
+	writeJSONMessage(w, http.StatusOK, "Member reactivated")
+}
+
+func AdminSetRoles(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	member, ok := adminMember(w, r)
+	if !ok {
+		return
+	}
+	var rolesRequest SetRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&rolesRequest); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	roles := []string{RoleUser}
+	for _, role := range rolesRequest.Roles {
+		if !containsString(knownRoles, role) {
+			writeJSONMessage(w, http.StatusBadRequest, "Unknown role "+role+"; expected one of "+strings.Join(knownRoles, ", "))
+			return
+		}
+		if !containsString(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	if member.ID == principal.MemberID && !containsString(roles, RoleAdmin) {
+		writeJSONMessage(w, http.StatusBadRequest, "You cannot remove your own admin role")
+		return
+	}
+
+	if _, err := database.DBMain.SetMemberRoles(r.Context(), member.ID, roles); err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to update roles")
+		return
+	}
+	recordAudit(r, "members.roles", "member", strconv.FormatInt(member.ID, 10),
+		map[string]interface{}{"username": member.Username, "from": member.Roles, "to": roles})
+	writeJSONMessage(w, http.StatusOK, "Roles updated")
+}
+
+// AdminDeleteCard removes a member's card from the graph, e.g. for abusive
+// content. The User node and its relationships stay.
+func AdminDeleteCard(w http.ResponseWriter, r *http.Request) {
+	member, ok := adminMember(w, r)
+	if !ok {
+		return
+	}
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(r.Context())
+
+	deleted, err := session.ExecuteWrite(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := transaction.Run(r.Context(),
+			"MATCH (:User {username: $username})-[:HAS_CARD]->(c:Card) DETACH DELETE c RETURN count(c) AS deleted",
+			map[string]interface{}{"username": member.Username})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		deleted, _ := record.Get("deleted")
+		return deleted, nil
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to delete card")
+		return
+	}
+	if deleted.(int64) == 0 {
+		writeJSONMessage(w, http.StatusNotFound, "Member has no card")
+		return
+	}
+	recordAudit(r, "cards.delete", "member", strconv.FormatInt(member.ID, 10),
+		map[string]interface{}{"username": member.Username})
+	writeJSONMessage(w, http.StatusOK, "Card deleted")
+}
+
+func AdminGraphStats(w http.ResponseWriter, r *http.Request) {
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	stats, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := transaction.Run(r.Context(),
+			"CALL { MATCH (u:User) RETURN count(u) AS users } "+
+				"CALL { MATCH (c:Card) RETURN count(c) AS cards } "+
+				"CALL { MATCH (:User)-[f:FRIENDS_WITH]->(:User) RETURN count(f) / 2 AS friendships } "+
+				"CALL { MATCH (fr:FriendRequest) WHERE "+livePendingRequest+" RETURN count(fr) AS pending_requests } "+
+				"CALL { MATCH (u:User) WHERE NOT (u)-[:FRIENDS_WITH]-() RETURN count(u) AS isolated_users } "+
+				"RETURN users, cards, friendships, pending_requests, isolated_users",
+			nil)
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		return record.AsMap(), nil
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to compute graph stats")
+		return
+	}
+	recordAudit(r, "graph.stats", "", "", nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+func AdminListAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := database.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+		ActorID:    int64(queryInt(r, "actor_id", 0)),
+		Before:     int64(queryInt(r, "before", 0)),
+		Limit:      queryInt(r, "limit", defaultAdminPageSize),
+	}
+	if filter.Limit < 1 || filter.Limit > maxAdminPageSize || filter.ActorID < 0 || filter.Before < 0 {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid limit, actor_id or before")
+		return
+	}
+
+	entries, err := database.DBMain.ListAudit(r.Context(), filter)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to list audit log")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries})
+}
+
+func adminMember(w http.ResponseWriter, r *http.Request) (*database.MemberSummary, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONMessage(w, http.StatusNotFound, "Member not found")
+		return nil, false
+	}
+	member, err := database.DBMain.GetMemberSummary(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to load member")
+		return nil, false
+	}
+	if member == nil {
+		writeJSONMessage(w, http.StatusNotFound, "Member not found")
+		return nil, false
+	}
+	return member, true
+}
+
+// recordAudit writes an audit entry for the calling principal. Failures are
+// logged rather than failing a request whose action has already happened.
+func recordAudit(r *http.Request, action, targetType, targetID string, details map[string]interface{}) {
+	entry := database.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Details:    details,
+		IP:         clientIP(r),
+	}
+	if principal, ok := PrincipalFrom(r.Context()); ok {
+		entry.ActorID = principal.MemberID
+		entry.Actor = principal.Username
+	}
+	if err := database.DBMain.RecordAudit(r.Context(), entry); err != nil {
+		log.Println("Failed to record audit entry:", action, err)
+	}
+}
+
+func queryInt(r *http.Request, key string, fallback int) int {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -2,15 +2,13 @@ package handlers
 
 import (
 	"context"
-	"crypto/rand"
 	"database/sql"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"strings"
+	"net/mail"
 	"time"
 
 	"github.com/petr-discover/cmd/database"
@@ -19,6 +17,16 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+	// refreshReuseGrace is how long a rotated refresh token still yields its
+	// successor, for parallel requests sent with the same cookie.
+	refreshReuseGrace = 30 * time.Second
+)
+
+var errUserExists = errors.New("user already exists")
+
 type UserInfo struct {
 	Password string `json:"sub"`
 	Email    string `json:"email"`
@@ -37,12 +45,6 @@ type RegistRequest struct {
 	Username string `json:"username"`
 }
 
-func AuthCtx(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		next.ServeHTTP(w, r)
-	})
-}
-
 func CreateUser(w http.ResponseWriter, r *http.Request) {
 	var registrationRequest RegistRequest
 	err := json.NewDecoder(r.Body).Decode(&registrationRequest)
@@ -55,13 +57,20 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Both email and username needs to exist", http.StatusBadRequest)
 		return
 	}
+	if address, err := mail.ParseAddress(registrationRequest.Email); err != nil || address.Address != registrationRequest.Email {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
 
 	userInfo := UserInfo(registrationRequest)
-	err = storeUserInDatabase(userInfo)
+	memberID, err := storeUserInDatabase(userInfo, false)
 	if err != nil {
 		http.Error(w, "Failed to store user in the database", http.StatusInternalServerError)
 		return
 	}
+	if err := sendVerificationEmail(r.Context(), memberID, userInfo.Email); err != nil {
+		log.Println(err)
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte("message : success"))
@@ -78,6 +87,14 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	var isValidUser bool
 	var username string
 
+	account := loginRequest.Username
+	if account == "" {
+		account = loginAccount(r, loginRequest.Email)
+	}
+	if account != "" && !allowLoginAttempt(w, r, account) {
+		return
+	}
+
 	if loginRequest.Username != "" {
 		isValidUser = authenticateUserByUsername(loginRequest.Username, loginRequest.Password)
 		username = loginRequest.Username
@@ -89,7 +106,26 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if isValidUser {
-		err = handleJWTCookie(w, username)
+		// The password was right, so earlier failures stop counting even if
+		// the MFA step below is never completed.
+		recordLoginSuccess(r, account)
+
+		var principal *Principal
+		var challenged bool
+		principal, err = loadPrincipal(r, username)
+		if err == nil {
+			challenged, err = writeMFAChallenge(w, r, principal)
+			if challenged {
+				return
+			}
+		}
+		if err == nil {
+			err = handleJWTCookie(w, r, principal)
+		}
+		if err == errAccountSuspended {
+			http.Error(w, "Account is suspended", http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, "Error generating JWT cookie", http.StatusInternalServerError)
 			return
@@ -99,6 +135,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("message : success"))
 		}
 	} else {
+		recordLoginFailure(r, account)
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte("message : Invalid credentials"))
@@ -106,142 +143,53 @@ func Login(w http.ResponseWriter, r *http.Request) {
 }
 
 func Logout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "access_token",
-		Value:    "",
-		MaxAge:   -1,
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "refresh_token",
-		Value:    "",
-		MaxAge:   -1,
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-		Path:     "/",
-	})
+	if refreshToken, err := r.Cookie("refresh_token"); err == nil {
+		claims, err := internal.ParseJWT(refreshToken.Value, config.JWTSecretKey().RefreshKey)
+		if err == nil && claims.SessionID != "" {
+			if err := database.DBMain.RevokeRefreshFamily(r.Context(), claims.SessionID); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+	clearJWTCookies(w)
 
 	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte("message : Success"))
 }
 
-func GoogleAuth(w http.ResponseWriter, r *http.Request) {
-	state := generateStateOauthCookie(w)
-	config.GoogleAuthConfig()
-	url := config.GoogleOauthConfig.AuthCodeURL(state)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
-}
-
-func GoogleCallback(w http.ResponseWriter, r *http.Request) {
-	oauthstate, err := r.Cookie("oauthstate")
-	if err != nil {
-		log.Printf("Error retrieving oauthstate cookie: %v\n", err)
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	if r.FormValue("state") != oauthstate.Value {
-		log.Printf("Invalid google oauth state cookie: %s state: %s\n", oauthstate.Value, r.FormValue("state"))
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	data, err := getGoogleUserInfo(r.FormValue("code"))
-	if err != nil {
-		log.Println(err.Error())
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	userInfo, err := parseUserInfo(data)
-	if err != nil {
-		log.Println(err.Error())
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	username := removeGmailSuffix(userInfo.Email)
-	userInfo.Username = username
-	err = storeUserInDatabase(userInfo)
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	err := database.DBMain.RevokeMemberRefreshTokens(r.Context(), principal.MemberID)
 	if err != nil {
-		log.Println(err.Error())
-		w.Write([]byte(err.Error()))
-		return
-	}
-
-	err = handleJWTCookie(w, userInfo.Username)
-	if err != nil {
-		log.Println(err.Error())
-		w.Write([]byte(err.Error()))
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to revoke sessions")
 		return
-	} else {
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte("message : Success"))
-	}
-
-}
-
-func generateStateOauthCookie(w http.ResponseWriter) string {
-	expiration := time.Now().Add(1 * 24 * time.Hour)
-
-	b := make([]byte, 16)
-	rand.Read(b)
-	state := base64.URLEncoding.EncodeToString(b)
-	cookie := &http.Cookie{Name: "oauthstate", Value: state, Expires: expiration}
-	http.SetCookie(w, cookie)
-	return state
-}
-
-func getGoogleUserInfo(code string) ([]byte, error) {
-	token, err := config.GoogleOauthConfig.Exchange(context.Background(), code)
-	if err != nil {
-		return nil, fmt.Errorf("failed to exchange: %s", err.Error())
-	}
-
-	fmt.Println(config.GoogleURLAPI + token.AccessToken)
-	resp, err := http.Get(config.GoogleURLAPI + token.AccessToken)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get userInfo: %s", err.Error())
 	}
-
-	defer resp.Body.Close()
-	fmt.Println(resp.Body, resp.Status)
-	return io.ReadAll(resp.Body)
-}
-
-func parseUserInfo(data []byte) (UserInfo, error) {
-	var userInfo UserInfo
-	if err := json.Unmarshal(data, &userInfo); err != nil {
-		return UserInfo{}, fmt.Errorf("failed to parse user information: %s", err.Error())
-	}
-	return userInfo, nil
+	clearJWTCookies(w)
+	writeJSONMessage(w, http.StatusOK, "Logged out of all sessions")
 }
 
-func storeUserInDatabase(userInfo UserInfo) error {
+func storeUserInDatabase(userInfo UserInfo, emailVerified bool) (int64, error) {
 	existingUser, err := getUserByUsername(userInfo.Username)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve user: %s", err.Error())
+		return 0, fmt.Errorf("failed to retrieve user: %s", err.Error())
 	}
 
 	if existingUser == nil {
 		hashedPassword, err := HashPassword(userInfo.Password)
 		if err != nil {
-			return fmt.Errorf("failed to hash password: %s", err.Error())
+			return 0, fmt.Errorf("failed to hash password: %s", err.Error())
 		}
-		_, err = database.DBMain.Exec("INSERT INTO member (username, password, email) VALUES ($1, $2, $3)", userInfo.Username, hashedPassword, userInfo.Email)
+		memberID, err := database.DBMain.CreateMember(context.Background(), userInfo.Username, hashedPassword, userInfo.Email, emailVerified)
 		if err != nil {
-			return fmt.Errorf("failed to insert new user: %s", err.Error())
+			return 0, err
 		}
 
 		fmt.Println("User created successfully")
-		return nil
+		return memberID, nil
 	} else {
-		return fmt.Errorf("user already exists")
+		return 0, errUserExists
 	}
 }
 
@@ -278,23 +226,44 @@ func authenticateUserByEmail(email, password string) (string, bool) {
 	return username, err == nil
 }
 
-func handleJWTCookie(w http.ResponseWriter, username string) error {
-	accessToken, err := internal.GenerateJWT(username, config.JWTSecretKey().SecretKey, 15*time.Minute)
+// handleJWTCookie starts a new session (refresh token family) for principal
+// and sets the access and refresh cookies.
+func handleJWTCookie(w http.ResponseWriter, r *http.Request, principal *Principal) error {
+	refresh, err := database.DBMain.IssueRefreshToken(r.Context(), principal.MemberID, r.UserAgent(), clientIP(r), refreshTokenTTL)
 	if err != nil {
-		http.Error(w, "Error generating access token", http.StatusInternalServerError)
 		return err
 	}
+	return setJWTCookies(w, principal, refresh)
+}
 
-	refreshToken, err := internal.GenerateJWT(username, config.JWTSecretKey().RefreshKey, 7*24*time.Hour)
+func setJWTCookies(w http.ResponseWriter, principal *Principal, refresh database.RefreshToken) error {
+	principal.SessionID = refresh.FamilyID
+	claims := internal.Claims{
+		User:      principal.Username,
+		MemberID:  principal.MemberID,
+		Roles:     principal.Roles,
+		SessionID: refresh.FamilyID,
+	}
+	keys, err := internal.AccessKeySet()
+	if err != nil {
+		return err
+	}
+	accessToken, err := keys.Sign(claims, accessTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	refreshClaims := internal.Claims{User: principal.Username, SessionID: refresh.FamilyID}
+	refreshClaims.Id = refresh.ID
+	refreshToken, err := internal.GenerateJWT(refreshClaims, config.JWTSecretKey().RefreshKey, refreshTokenTTL)
 	if err != nil {
-		http.Error(w, "Error generating refresh token", http.StatusInternalServerError)
 		return err
 	}
 
 	http.SetCookie(w, &http.Cookie{
 		Name:     "access_token",
 		Value:    accessToken,
-		Expires:  time.Now().Add(15 * time.Minute),
+		Expires:  time.Now().Add(accessTokenTTL),
 		HttpOnly: true,
 		Secure:   false,
 		Path:     "/",
@@ -303,7 +272,7 @@ func handleJWTCookie(w http.ResponseWriter, username string) error {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "refresh_token",
 		Value:    refreshToken,
-		Expires:  time.Now().Add(7 * 24 * time.Hour),
+		Expires:  time.Now().Add(refreshTokenTTL),
 		HttpOnly: true,
 		Secure:   false,
 		Path:     "/",
@@ -311,6 +280,26 @@ func handleJWTCookie(w http.ResponseWriter, username string) error {
 	return nil
 }
 
+func clearJWTCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "access_token",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+}
+
 func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -319,12 +308,6 @@ func HashPassword(password string) (string, error) {
 	return string(hashedPassword), nil
 }
 
-func removeGmailSuffix(email string) string {
-	username := strings.Split(email, "@")
-	temp := username[0]
-	return temp
-}
-
 func getUserByUsername(username string) (*UserInfo, error) {
 	var user UserInfo
 	err := database.DBMain.QueryRow("SELECT email, password FROM member WHERE username = $1", username).
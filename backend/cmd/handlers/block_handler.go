@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+)
+
+const (
+	defaultBlockListLimit = 50
+	maxBlockListLimit     = 200
+)
+
+// notBlocked is a Cypher predicate that holds when neither of the users a
+// and b has blocked the other. Every query that shows one user to another
+// must apply it.
+func notBlocked(a, b string) string {
+	return "NOT exists { (" + a + ")-[:BLOCKED]-(" + b + ") }"
+}
+
+type BlockedUser struct {
+	CardSummary
+	BlockedAt *time.Time `json:"blocked_at,omitempty"`
+}
+
+// BlockUser blocks {username}: it removes the friendship and any pending
+// requests between the two, and hides each from the other everywhere.
+func BlockUser(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	target := chi.URLParam(r, "username")
+	if target == username {
+		writeJSONMessage(w, http.StatusBadRequest, "You cannot block yourself")
+		return
+	}
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(r.Context())
+
+	_, err := session.ExecuteWrite(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		params := map[string]interface{}{"username": username, "target": target}
+		record, err := singleRecord(transaction, r,
+			"OPTIONAL MATCH (me:User {username: $username}), (other:User {username: $target}) "+
+				"FOREACH (_ IN CASE WHEN me IS NULL OR other IS NULL THEN [] ELSE [1] END | "+
+				"MERGE (me)-[b:BLOCKED]->(other) ON CREATE SET b.blocked_at = datetime()) "+
+				"RETURN me IS NOT NULL AND other IS NOT NULL AS found",
+			params)
+		if err != nil {
+			return nil, err
+		}
+		if found, _ := record.Get("found"); found != true {
+			return nil, errFriendNotFound
+		}
+
+		_, err = transaction.Run(r.Context(),
+			"MATCH (:User {username: $username})-[f:FRIENDS_WITH]-(:User {username: $target}) DELETE f",
+			params)
+		if err != nil {
+			return nil, err
+		}
+		_, err = transaction.Run(r.Context(),
+			"MATCH (a:User)-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest {status: 'pending'})-[:TO_USER]->(b:User) "+
+				"WHERE (a.username = $username AND b.username = $target) OR (a.username = $target AND b.username = $username) "+
+				"DETACH DELETE fr",
+			params)
+		return nil, err
+	})
+	if errors.Is(err, errFriendNotFound) {
+		writeJSONMessage(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to block user")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "User blocked")
+}
+
+func UnblockUser(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	target := chi.URLParam(r, "username")
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(r.Context())
+
+	deleted, err := session.ExecuteWrite(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		record, err := singleRecord(transaction, r,
+			"OPTIONAL MATCH (:User {username: $username})-[b:BLOCKED]->(:User {username: $target}) "+
+				"DELETE b RETURN count(b) AS deleted",
+			map[string]interface{}{"username": username, "target": target})
+		if err != nil {
+			return nil, err
+		}
+		deleted, _ := record.Get("deleted")
+		return deleted, nil
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to unblock user")
+		return
+	}
+	if deleted == int64(0) {
+		writeJSONMessage(w, http.StatusNotFound, "User is not blocked")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "User unblocked")
+}
+
+// ListBlockedUsers lists the users the caller has blocked, newest first.
+// Users who blocked the caller are not shown.
+func ListBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	limit := queryInt(r, "limit", defaultBlockListLimit)
+	offset := queryInt(r, "offset", 0)
+	if limit < 1 || limit > maxBlockListLimit || offset < 0 {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid limit or offset")
+		return
+	}
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	blocked, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := transaction.Run(r.Context(),
+			"MATCH (:User {username: $username})-[b:BLOCKED]->(other:User) "+
+				"OPTIONAL MATCH (other)-[:HAS_CARD]->(c:Card) "+
+				"RETURN other.username AS username, properties(c) AS card, b.blocked_at AS blocked_at "+
+				"ORDER BY b.blocked_at DESC, username SKIP $offset LIMIT $limit",
+			map[string]interface{}{"username": username, "offset": offset, "limit": limit})
+		if err != nil {
+			return nil, err
+		}
+		records, err := result.Collect(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		blocked := []BlockedUser{}
+		for _, record := range records {
+			props := record.AsMap()
+			other, _ := props["username"].(string)
+			card, _ := props["card"].(map[string]interface{})
+			user := BlockedUser{CardSummary: cardSummary(other, card, strangerDistance)}
+			if blockedAt, ok := props["blocked_at"].(time.Time); ok {
+				user.BlockedAt = &blockedAt
+			}
+			blocked = append(blocked, user)
+		}
+		return blocked, nil
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to list blocked users")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"blocked": blocked})
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/cmd/models"
+	"github.com/petr-discover/internal"
+)
+
+var (
+	errCardNotFound    = errors.New("card not found")
+	errVersionMismatch = errors.New("card version does not match If-Match")
+)
+
+type cardValidationError struct {
+	Errors []models.FieldError
+}
+
+func (e cardValidationError) Error() string {
+	return "invalid card"
+}
+
+// PatchUser applies an RFC 7396 merge patch to the caller's card, e.g.
+// {"school": "UCI", "phone": null, "visibility": {"school": "friends"}}.
+// With If-Match the patch only applies to that card version.
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+		writeJSONMessage(w, http.StatusUnsupportedMediaType, "Content-Type must be application/merge-patch+json")
+		return
+	}
+	var patch map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Request body must be a JSON object")
+		return
+	}
+	applyCardPatch(w, r, patch, "")
+}
+
+// applyCardPatch is shared by PATCH, the older PUT form of the card update
+// and revision restores, which pass the restored revision's id. Every
+// update is recorded as a card revision.
+func applyCardPatch(w http.ResponseWriter, r *http.Request, patch map[string]interface{}, restoredFrom string) {
+	username := MustPrincipal(r.Context()).Username
+	if errs := checkCardPatch(patch); len(errs) > 0 {
+		writeCardErrors(w, errs)
+		return
+	}
+	expected, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid If-Match header")
+		return
+	}
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(r.Context())
+
+	updated, err := session.ExecuteWrite(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		params := map[string]interface{}{"username": username}
+		record, err := singleRecord(transaction, r,
+			"OPTIONAL MATCH (:User {username: $username})-[:HAS_CARD]->(c:Card) RETURN properties(c) AS card",
+			params)
+		if err != nil {
+			return nil, err
+		}
+		value, _ := record.Get("card")
+		props, _ := value.(map[string]interface{})
+		if props == nil {
+			return nil, errCardNotFound
+		}
+		current := models.CardFromProps(props)
+		if expected >= 0 && current.Version != expected {
+			return nil, errVersionMismatch
+		}
+
+		next, err := mergeCardPatch(current, patch)
+		if err != nil {
+			return nil, err
+		}
+		if errs := next.Validate(); len(errs) > 0 {
+			return nil, cardValidationError{Errors: errs}
+		}
+
+		params["props"] = next.Props()
+		params["version"] = current.Version
+		record, err = singleRecord(transaction, r,
+			"OPTIONAL MATCH (:User {username: $username})-[:HAS_CARD]->(c:Card) "+
+				"WHERE coalesce(c.version, 0) = $version "+
+				"FOREACH (_ IN CASE WHEN c IS NULL THEN [] ELSE [1] END | SET c += $props, c.version = $version + 1) "+
+				"RETURN c IS NOT NULL AS updated",
+			params)
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := record.Get("updated"); ok != true {
+			return nil, errVersionMismatch
+		}
+		if err := recordBaselineRevision(r.Context(), transaction, username, current); err != nil {
+			return nil, err
+		}
+		next.Version = current.Version + 1
+		if err := recordCardRevision(r.Context(), transaction, username, username, next, current.Diff(next), restoredFrom); err != nil {
+			return nil, err
+		}
+		return next, nil
+	})
+
+	var invalid cardValidationError
+	switch {
+	case errors.Is(err, errCardNotFound):
+		writeJSONMessage(w, http.StatusNotFound, "You have no card")
+	case errors.Is(err, errVersionMismatch):
+		writeJSONMessage(w, http.StatusPreconditionFailed, "Card was changed by another request; reload it and try again")
+	case errors.As(err, &invalid):
+		writeCardErrors(w, invalid.Errors)
+	case err != nil:
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to update card properties")
+	default:
+		card := updated.(models.Card)
+		w.Header().Set("ETag", cardETag(card.Version))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{"card": card, "version": card.Version})
+	}
+}
+
+// checkCardPatch reports members that are not card fields or not strings.
+func checkCardPatch(patch map[string]interface{}) []models.FieldError {
+	errs := []models.FieldError{}
+	for name, value := range patch {
+		switch name {
+		case "version":
+			errs = append(errs, models.FieldError{Field: name, Message: "is read-only; use If-Match"})
+			continue
+		case "visibility":
+			levels, ok := value.(map[string]interface{})
+			if !ok && value != nil {
+				errs = append(errs, models.FieldError{Field: name, Message: "must be an object"})
+				continue
+			}
+			for field, level := range levels {
+				if _, known := models.CardFieldByName(field); !known {
+					errs = append(errs, models.FieldError{Field: "visibility." + field, Message: "unknown field"})
+				} else if _, ok := level.(string); !ok && level != nil {
+					errs = append(errs, models.FieldError{Field: "visibility." + field, Message: "must be a string"})
+				}
+			}
+			continue
+		}
+		field, known := models.CardFieldByName(name)
+		if !known || field.VisibilityOnly {
+			errs = append(errs, models.FieldError{Field: name, Message: "unknown field"})
+		} else if _, ok := value.(string); !ok && value != nil {
+			errs = append(errs, models.FieldError{Field: name, Message: "must be a string"})
+		}
+	}
+	return errs
+}
+
+func mergeCardPatch(current models.Card, patch map[string]interface{}) (models.Card, error) {
+	var next models.Card
+	raw, err := json.Marshal(current)
+	if err != nil {
+		return next, err
+	}
+	var document interface{}
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return next, err
+	}
+	raw, err = json.Marshal(internal.MergePatch(document, patch))
+	if err != nil {
+		return next, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&next); err != nil {
+		return next, err
+	}
+	next.Version = current.Version
+	return next, nil
+}
+
+func writeCardErrors(w http.ResponseWriter, errs []models.FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "Invalid card", "errors": errs})
+}
+
+func cardETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch returns the version an If-Match header requires, or -1 when
+// there is no header or it is "*".
+func parseIfMatch(header string) (int64, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return -1, true
+	}
+	header = strings.TrimPrefix(header, "W/")
+	value, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version < 0 {
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/petr-discover/cmd/models"
+)
+
+func TestCheckCardPatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		patch  map[string]interface{}
+		fields []string
+	}{
+		{"fields and levels", map[string]interface{}{
+			"bio":        "Hi",
+			"phone":      nil,
+			"visibility": map[string]interface{}{"email": "private", "friend_list": nil},
+		}, nil},
+		{"visibility removed", map[string]interface{}{"visibility": nil}, nil},
+		{"unknown field", map[string]interface{}{"age": "21"}, []string{"age"}},
+		{"visibility-only field", map[string]interface{}{"friend_list": "x"}, []string{"friend_list"}},
+		{"version", map[string]interface{}{"version": 3}, []string{"version"}},
+		{"non-string value", map[string]interface{}{"bio": 42}, []string{"bio"}},
+		{"visibility not an object", map[string]interface{}{"visibility": "public"}, []string{"visibility"}},
+		{"unknown visibility field", map[string]interface{}{"visibility": map[string]interface{}{"age": "public"}}, []string{"visibility.age"}},
+		{"non-string level", map[string]interface{}{"visibility": map[string]interface{}{"email": true}}, []string{"visibility.email"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, err := range checkCardPatch(tt.patch) {
+				fields = append(fields, err.Field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("errors on %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestMergeCardPatch(t *testing.T) {
+	current := models.Card{
+		FirstName:  "Petr",
+		Bio:        "Hi",
+		Phone:      "949-824-5011",
+		Visibility: map[string]string{"email": "private", "phone": "friends"},
+		Version:    4,
+	}
+	tests := []struct {
+		name  string
+		patch map[string]interface{}
+		want  models.Card
+	}{
+		{"set and remove fields", map[string]interface{}{"city": "Irvine", "bio": nil}, models.Card{
+			FirstName:  "Petr",
+			City:       "Irvine",
+			Phone:      "949-824-5011",
+			Visibility: map[string]string{"email": "private", "phone": "friends"},
+			Version:    4,
+		}},
+		{"merge visibility levels", map[string]interface{}{
+			"visibility": map[string]interface{}{"phone": nil, "bio": "friends"},
+		}, models.Card{
+			FirstName:  "Petr",
+			Bio:        "Hi",
+			Phone:      "949-824-5011",
+			Visibility: map[string]string{"email": "private", "bio": "friends"},
+			Version:    4,
+		}},
+		{"remove all visibility levels", map[string]interface{}{"visibility": nil}, models.Card{
+			FirstName: "Petr",
+			Bio:       "Hi",
+			Phone:     "949-824-5011",
+			Version:   4,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeCardPatch(current, tt.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged card = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := mergeCardPatch(current, map[string]interface{}{"age": "21"}); err == nil {
+		t.Error("expected an unknown field to be rejected")
+	}
+	if current.Bio != "Hi" || current.Visibility["phone"] != "friends" {
+		t.Error("merging modified the current card")
+	}
+}
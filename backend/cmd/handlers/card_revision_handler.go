@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/cmd/models"
+)
+
+const (
+	defaultRevisionListLimit = 20
+	maxRevisionListLimit     = 100
+)
+
+var errRevisionNotFound = errors.New("card revision not found")
+
+// CardRevision is a user's card as it stood after one update. Revisions
+// hang off the User rather than the Card, so they outlive a deleted card.
+// A revision without an author is the card as it was before history was
+// recorded.
+type CardRevision struct {
+	ID           string              `json:"id"`
+	Version      int64               `json:"version"`
+	Author       string              `json:"author,omitempty"`
+	CreatedAt    *time.Time          `json:"created_at,omitempty"`
+	RestoredFrom string              `json:"restored_from,omitempty"`
+	Changes      []models.CardChange `json:"changes"`
+	Card         *models.Card        `json:"card,omitempty"`
+}
+
+// recordCardRevision stores card, which has just been written, as a new
+// revision of username's card.
+func recordCardRevision(ctx context.Context, transaction neo4j.ManagedTransaction, username, author string, card models.Card, changes []models.CardChange, restoredFrom string) error {
+	cardJSON, err := json.Marshal(card)
+	if err != nil {
+		return err
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{
+		"username": username,
+		"version":  card.Version,
+		"author":   nil,
+		"card":     string(cardJSON),
+		"changes":  string(changesJSON),
+		"restored": nil,
+	}
+	if author != "" {
+		params["author"] = author
+	}
+	if restoredFrom != "" {
+		params["restored"] = restoredFrom
+	}
+	_, err = transaction.Run(ctx,
+		"MATCH (u:User {username: $username}) "+
+			"CREATE (u)-[:HAS_CARD_REVISION]->(:CardRevision {id: randomUUID(), version: $version, author: $author, "+
+			"created_at: datetime(), card: $card, changes: $changes, restored_from: $restored})",
+		params)
+	return err
+}
+
+// recordBaselineRevision keeps the current card of a user whose history is
+// missing it, such as a card written before revisions were recorded, so the
+// next update does not lose it.
+func recordBaselineRevision(ctx context.Context, transaction neo4j.ManagedTransaction, username string, card models.Card) error {
+	result, err := transaction.Run(ctx,
+		"MATCH (:User {username: $username})-[:HAS_CARD_REVISION]->(rev:CardRevision) "+
+			"RETURN rev.version AS version ORDER BY rev.version DESC, rev.created_at DESC LIMIT 1",
+		map[string]interface{}{"username": username})
+	if err != nil {
+		return err
+	}
+	records, err := result.Collect(ctx)
+	if err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		if version, _ := records[0].Get("version"); version == card.Version {
+			return nil
+		}
+	}
+	return recordCardRevision(ctx, transaction, username, "", card, []models.CardChange{}, "")
+}
+
+// ListCardRevisions lists the caller's card revisions, newest first.
+func ListCardRevisions(w http.ResponseWriter, r *http.Request) {
+	listCardRevisions(w, r, MustPrincipal(r.Context()).Username)
+}
+
+func GetCardRevision(w http.ResponseWriter, r *http.Request) {
+	getCardRevision(w, r, MustPrincipal(r.Context()).Username)
+}
+
+// RestoreCardRevision makes a past revision the current card. The restore
+// is itself recorded as a new revision, and honours If-Match like PATCH.
+func RestoreCardRevision(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	revision, err := loadCardRevision(r, username, chi.URLParam(r, "revision"))
+	if errors.Is(err, errRevisionNotFound) {
+		writeJSONMessage(w, http.StatusNotFound, "Revision not found")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to load revision")
+		return
+	}
+	applyCardPatch(w, r, revision.Card.Patch(), revision.ID)
+}
+
+// AdminListCardRevisions lists a member's card revisions. With
+// ?before=<RFC 3339 time> the first revision is the card as it stood then,
+// e.g. when it was reported.
+func AdminListCardRevisions(w http.ResponseWriter, r *http.Request) {
+	member, ok := adminMember(w, r)
+	if !ok {
+		return
+	}
+	if listCardRevisions(w, r, member.Username) {
+		recordAudit(r, "cards.revisions.list", "member", strconv.FormatInt(member.ID, 10),
+			map[string]interface{}{"username": member.Username})
+	}
+}
+
+func AdminGetCardRevision(w http.ResponseWriter, r *http.Request) {
+	member, ok := adminMember(w, r)
+	if !ok {
+		return
+	}
+	if getCardRevision(w, r, member.Username) {
+		recordAudit(r, "cards.revisions.view", "member", strconv.FormatInt(member.ID, 10),
+			map[string]interface{}{"username": member.Username, "revision": chi.URLParam(r, "revision")})
+	}
+}
+
+// listCardRevisions writes username's revisions without their card bodies
+// and reports whether it succeeded.
+func listCardRevisions(w http.ResponseWriter, r *http.Request, username string) bool {
+	limit := queryInt(r, "limit", defaultRevisionListLimit)
+	offset := queryInt(r, "offset", 0)
+	if limit < 1 || limit > maxRevisionListLimit || offset < 0 {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid limit or offset")
+		return false
+	}
+	var before interface{}
+	if value := r.URL.Query().Get("before"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeJSONMessage(w, http.StatusBadRequest, "before must be an RFC 3339 time")
+			return false
+		}
+		before = t
+	}
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	revisions, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := transaction.Run(r.Context(),
+			"MATCH (:User {username: $username})-[:HAS_CARD_REVISION]->(rev:CardRevision) "+
+				"WHERE $before IS NULL OR rev.created_at <= $before "+
+				"RETURN properties(rev) AS revision ORDER BY rev.version DESC, rev.created_at DESC SKIP $offset LIMIT $limit",
+			map[string]interface{}{"username": username, "before": before, "offset": offset, "limit": limit})
+		if err != nil {
+			return nil, err
+		}
+		records, err := result.Collect(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		revisions := []CardRevision{}
+		for _, record := range records {
+			value, _ := record.Get("revision")
+			props, _ := value.(map[string]interface{})
+			revision, err := cardRevisionFromProps(props)
+			if err != nil {
+				return nil, err
+			}
+			revision.Card = nil
+			revisions = append(revisions, revision)
+		}
+		return revisions, nil
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to list card revisions")
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"revisions": revisions})
+	return true
+}
+
+// getCardRevision writes one of username's revisions, with the full card,
+// and reports whether it was found.
+func getCardRevision(w http.ResponseWriter, r *http.Request, username string) bool {
+	revision, err := loadCardRevision(r, username, chi.URLParam(r, "revision"))
+	if errors.Is(err, errRevisionNotFound) {
+		writeJSONMessage(w, http.StatusNotFound, "Revision not found")
+		return false
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to load revision")
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(revision)
+	return true
+}
+
+func loadCardRevision(r *http.Request, username, id string) (*CardRevision, error) {
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	revision, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		record, err := singleRecord(transaction, r,
+			"OPTIONAL MATCH (:User {username: $username})-[:HAS_CARD_REVISION]->(rev:CardRevision {id: $id}) "+
+				"RETURN properties(rev) AS revision",
+			map[string]interface{}{"username": username, "id": id})
+		if err != nil {
+			return nil, err
+		}
+		value, _ := record.Get("revision")
+		props, _ := value.(map[string]interface{})
+		if props == nil {
+			return nil, errRevisionNotFound
+		}
+		revision, err := cardRevisionFromProps(props)
+		if err != nil {
+			return nil, err
+		}
+		return &revision, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revision.(*CardRevision), nil
+}
+
+func cardRevisionFromProps(props map[string]interface{}) (CardRevision, error) {
+	revision := CardRevision{Changes: []models.CardChange{}, Card: &models.Card{}}
+	revision.ID, _ = props["id"].(string)
+	revision.Version, _ = props["version"].(int64)
+	revision.Author, _ = props["author"].(string)
+	revision.RestoredFrom, _ = props["restored_from"].(string)
+	if createdAt, ok := props["created_at"].(time.Time); ok {
+		revision.CreatedAt = &createdAt
+	}
+	if changes, ok := props["changes"].(string); ok {
+		if err := json.Unmarshal([]byte(changes), &revision.Changes); err != nil {
+			return revision, err
+		}
+	}
+	if card, ok := props["card"].(string); ok {
+		if err := json.Unmarshal([]byte(card), revision.Card); err != nil {
+			return revision, err
+		}
+	}
+	revision.Card.Version = revision.Version
+	return revision, nil
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/petr-discover/cmd/models"
+)
+
+// strangerDistance is the distance of a viewer with no path to the owner
+// within friends-of-friends range.
+const strangerDistance = 3
+
+// CardSummary is how other users appear in lists. The card fields are empty
+// when the card is missing or not visible to the viewer.
+type CardSummary struct {
+	Username         string `json:"username"`
+	FirstName        string `json:"first_name,omitempty"`
+	LastName         string `json:"last_name,omitempty"`
+	UserProfileImage string `json:"user_profile_image,omitempty"`
+}
+
+// cardSummary builds the summary of username's card as seen by a viewer
+// distance FRIENDS_WITH hops away.
+func cardSummary(username string, card map[string]interface{}, distance int) CardSummary {
+	summary := CardSummary{Username: username}
+	if fieldVisible(card, "first_name", distance) {
+		summary.FirstName, _ = card["first_name"].(string)
+	}
+	if fieldVisible(card, "last_name", distance) {
+		summary.LastName, _ = card["last_name"].(string)
+	}
+	if fieldVisible(card, "user_profile_image", distance) {
+		summary.UserProfileImage, _ = card["user_profile_image"].(string)
+	}
+	return summary
+}
+
+// fieldLevel is the field's own level, else the card-wide visibility, else
+// the schema default.
+func fieldLevel(card map[string]interface{}, field models.CardField) string {
+	if level, ok := card[models.VisibilityProperty(field.Name)].(string); ok && models.MaxDistance(level) >= 0 {
+		return level
+	}
+	if level, ok := card["visibility"].(string); ok && models.MaxDistance(level) >= 0 {
+		return level
+	}
+	return field.DefaultVisibility
+}
+
+func fieldVisible(card map[string]interface{}, name string, distance int) bool {
+	field, ok := models.CardFieldByName(name)
+	if card == nil || !ok {
+		return false
+	}
+	return distance <= models.MaxDistance(fieldLevel(card, field))
+}
+
+// visibleCard returns the schema fields of card that a viewer at distance
+// may see. Unknown properties are never returned.
+func visibleCard(card map[string]interface{}, distance int) map[string]interface{} {
+	visible := map[string]interface{}{}
+	for _, field := range models.CardFields {
+		value, ok := card[field.Name]
+		if field.VisibilityOnly || !ok || !fieldVisible(card, field.Name, distance) {
+			continue
+		}
+		visible[field.Name] = value
+	}
+	return visible
+}
+
+// cardVisibilities is the effective level of every field, for the owner.
+func cardVisibilities(card map[string]interface{}) map[string]string {
+	levels := map[string]string{}
+	for _, field := range models.CardFields {
+		levels[field.Name] = fieldLevel(card, field)
+	}
+	return levels
+}
+
+// fieldVisibleCypher is fieldVisible as a Cypher expression over a card c
+// and a distance variable, for filtering and sorting on visible fields.
+func fieldVisibleCypher(name string) string {
+	field, _ := models.CardFieldByName(name)
+	return fmt.Sprintf("(c IS NOT NULL AND distance <= CASE coalesce(c.%s, c.visibility, '%s') "+
+		"WHEN '%s' THEN 1000 WHEN '%s' THEN 2 WHEN '%s' THEN 1 ELSE 0 END)",
+		models.VisibilityProperty(field.Name), field.DefaultVisibility,
+		models.VisibilityPublic, models.VisibilityFriendsOfFriends, models.VisibilityFriends)
+}
+
+// distanceCypher is the FRIENDS_WITH distance from viewer to owner, capped
+// at strangerDistance. Friends the viewer has blocked, or is blocked by, do
+// not connect them, as in suggestions.
+func distanceCypher(viewer, owner string) string {
+	return fmt.Sprintf("CASE WHEN %[1]s = %[2]s THEN 0 "+
+		"WHEN exists { (%[1]s)-[:FRIENDS_WITH]-(%[2]s) } THEN 1 "+
+		"WHEN exists { MATCH (%[1]s)-[:FRIENDS_WITH]-(via:User)-[:FRIENDS_WITH]-(%[2]s) WHERE NOT exists { (%[1]s)-[:BLOCKED]-(via) } } THEN 2 "+
+		"ELSE %[3]d END", viewer, owner, strangerDistance)
+}
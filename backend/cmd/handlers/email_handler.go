@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/config"
+	"github.com/petr-discover/internal"
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyEmail accepts the token either as ?token= (the emailed link) or in a
+// JSON body.
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		var verifyRequest VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&verifyRequest); err != nil {
+			writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		token = verifyRequest.Token
+	}
+	if token == "" {
+		writeJSONMessage(w, http.StatusBadRequest, "Missing verification token")
+		return
+	}
+
+	_, err := database.DBMain.VerifyEmail(r.Context(), internal.HashToken(token))
+	if err == database.ErrVerificationTokenInvalid {
+		writeJSONMessage(w, http.StatusBadRequest, "Verification token is invalid or expired")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "Email verified")
+}
+
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+
+	var email string
+	var verified bool
+	err := database.DBMain.QueryRowContext(r.Context(),
+		"SELECT email, email_verified_at IS NOT NULL FROM member WHERE id = $1", principal.MemberID).
+		Scan(&email, &verified)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+	if verified {
+		writeJSONMessage(w, http.StatusBadRequest, "Email is already verified")
+		return
+	}
+
+	err = sendVerificationEmail(r.Context(), principal.MemberID, email)
+	var throttled *database.ThrottledError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		writeJSONMessage(w, http.StatusTooManyRequests, "Verification email was sent recently, try again later")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "Verification email sent")
+}
+
+// RequireVerifiedEmail is a policy hook for routes that should only be used
+// once the caller has confirmed their email address.
+func RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFrom(r.Context())
+		if !ok {
+			writeJSONMessage(w, http.StatusUnauthorized, "Not logged in")
+			return
+		}
+		verified, err := database.DBMain.IsEmailVerified(r.Context(), principal.MemberID)
+		if err != nil {
+			log.Println(err)
+			writeJSONMessage(w, http.StatusInternalServerError, "Failed to check email verification")
+			return
+		}
+		if !verified {
+			writeJSONMessage(w, http.StatusForbidden, "Email address is not verified")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func sendVerificationEmail(ctx context.Context, memberID int64, email string) error {
+	token, tokenHash, err := internal.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	ttl := config.EmailVerificationTTL()
+	err = database.DBMain.CreateEmailVerificationToken(ctx, memberID, tokenHash, ttl, config.EmailVerificationResendInterval())
+	if err != nil {
+		return err
+	}
+
+	mailer, err := internal.DefaultMailer()
+	if err != nil {
+		return err
+	}
+	link := config.AppBaseURL() + "/api/v1/auth/email/verify?token=" + url.QueryEscape(token)
+	return mailer.Send(ctx, internal.Mail{
+		To:      email,
+		Subject: "Verify your petr-discover email",
+		Body: fmt.Sprintf("Welcome to petr-discover!\n\n"+
+			"Confirm your email address within %d hours by opening:\n%s", int(ttl.Hours()), link),
+	})
+}
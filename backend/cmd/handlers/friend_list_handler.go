@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/cmd/models"
+)
+
+const (
+	defaultFriendListLimit = 50
+	maxFriendListLimit     = 200
+)
+
+// friendListSorts maps the sort parameter to a Cypher expression. Names
+// only count when visible, so sorting cannot reveal hidden ones.
+var friendListSorts = map[string]string{
+	"username": "toLower(f.username)",
+	"name":     "CASE WHEN name = '' THEN toLower(f.username) ELSE toLower(name) END",
+	"since":    "since",
+}
+
+var errListHidden = errors.New("friend list is not visible")
+
+type FriendSummary struct {
+	CardSummary
+	Since *time.Time `json:"since,omitempty"`
+}
+
+type FriendList struct {
+	Friends []FriendSummary `json:"friends"`
+	Total   int64           `json:"total"`
+}
+
+// ListFriends lists the caller's friends, or those of {username} when that
+// user's friend_list visibility admits the caller.
+//
+// Query parameters: q (name filter), sort (username, name or since), order
+// (asc or desc), limit and offset.
+func ListFriends(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "username")
+	if owner == "" {
+		owner = MustPrincipal(r.Context()).Username
+	}
+	listFriends(w, r, owner,
+		"MATCH (owner:User {username: $owner})-[rel:FRIENDS_WITH]->(f:User) ")
+}
+
+// ListMutualFriends lists friends the caller shares with {username}. Since
+// is when the caller became friends with each of them.
+func ListMutualFriends(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "username")
+	if owner == MustPrincipal(r.Context()).Username {
+		writeJSONMessage(w, http.StatusBadRequest, "Mutual friends need another user")
+		return
+	}
+	listFriends(w, r, owner,
+		"MATCH (viewer)-[rel:FRIENDS_WITH]->(f:User)-[:FRIENDS_WITH]->(owner:User {username: $owner}) ")
+}
+
+func listFriends(w http.ResponseWriter, r *http.Request, owner, match string) {
+	viewer := MustPrincipal(r.Context()).Username
+	query := r.URL.Query()
+	limit := queryInt(r, "limit", defaultFriendListLimit)
+	offset := queryInt(r, "offset", 0)
+	if limit < 1 || limit > maxFriendListLimit || offset < 0 {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid limit or offset")
+		return
+	}
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = "username"
+	}
+	sortExpression, ok := friendListSorts[sort]
+	if !ok {
+		writeJSONMessage(w, http.StatusBadRequest, "sort must be one of username, name or since")
+		return
+	}
+	order := strings.ToUpper(query.Get("order"))
+	if order == "" {
+		order = "ASC"
+	}
+	if order != "ASC" && order != "DESC" {
+		writeJSONMessage(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+	params := map[string]interface{}{
+		"viewer": viewer,
+		"owner":  owner,
+		"q":      strings.ToLower(strings.TrimSpace(query.Get("q"))),
+		"offset": offset,
+		"limit":  limit,
+	}
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	list, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		record, err := singleRecord(transaction, r,
+			"OPTIONAL MATCH (viewer:User {username: $viewer}) "+
+				"OPTIONAL MATCH (f:User {username: $owner}) "+
+				"OPTIONAL MATCH (f)-[:HAS_CARD]->(c:Card) "+
+				"WITH viewer, f, c, "+distanceCypher("viewer", "f")+" AS distance "+
+				"RETURN f IS NOT NULL AND "+notBlocked("viewer", "f")+" AS found, distance, properties(c) AS card",
+			params)
+		if err != nil {
+			return nil, err
+		}
+		if found, _ := record.Get("found"); found != true {
+			return nil, errFriendNotFound
+		}
+		distance, _ := record.Get("distance")
+		ownerDistance, _ := distance.(int64)
+		value, _ := record.Get("card")
+		card, _ := value.(map[string]interface{})
+		if card == nil {
+			card = map[string]interface{}{}
+		}
+		if !fieldVisible(card, models.FriendListField, int(ownerDistance)) {
+			return nil, errListHidden
+		}
+
+		record, err = singleRecord(transaction, r,
+			"MATCH (viewer:User {username: $viewer}) "+
+				match+
+				"WHERE "+notBlocked("viewer", "f")+" "+
+				"OPTIONAL MATCH (f)-[:HAS_CARD]->(c:Card) "+
+				"WITH viewer, f, rel.since AS since, c, "+distanceCypher("viewer", "f")+" AS distance "+
+				"WITH f, since, c, distance, "+
+				"trim(CASE WHEN "+fieldVisibleCypher("first_name")+" THEN coalesce(c.first_name, '') ELSE '' END + ' ' + "+
+				"CASE WHEN "+fieldVisibleCypher("last_name")+" THEN coalesce(c.last_name, '') ELSE '' END) AS name "+
+				"WHERE $q = '' OR toLower(f.username) CONTAINS $q OR toLower(name) CONTAINS $q "+
+				"WITH f, since, c, distance, name ORDER BY "+sortExpression+" "+order+", f.username "+
+				"WITH collect({username: f.username, since: since, card: properties(c), distance: distance}) AS rows "+
+				"RETURN size(rows) AS total, rows[$offset..$offset + $limit] AS page",
+			params)
+		if err != nil {
+			return nil, err
+		}
+
+		list := &FriendList{Friends: []FriendSummary{}}
+		if total, ok := record.Get("total"); ok {
+			list.Total, _ = total.(int64)
+		}
+		page, _ := record.Get("page")
+		rows, _ := page.([]interface{})
+		for _, row := range rows {
+			props, _ := row.(map[string]interface{})
+			username, _ := props["username"].(string)
+			card, _ := props["card"].(map[string]interface{})
+			distance, _ := props["distance"].(int64)
+			friend := FriendSummary{CardSummary: cardSummary(username, card, int(distance))}
+			if since, ok := props["since"].(time.Time); ok {
+				friend.Since = &since
+			}
+			list.Friends = append(list.Friends, friend)
+		}
+		return list, nil
+	})
+	if errors.Is(err, errFriendNotFound) {
+		writeJSONMessage(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if errors.Is(err, errListHidden) {
+		writeJSONMessage(w, http.StatusForbidden, "Friend list is not visible")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to list friends")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/config"
+)
+
+const (
+	maxFriendRequestMessage   = 280
+	defaultFriendRequestLimit = 25
+	maxFriendRequestLimit     = 100
+)
+
+// FriendRequest statuses. Only pending requests that have not passed
+// expires_at are live. Declined requests are kept for the decline cooldown
+// and everything else is swept; see SweepFriendRequests.
+const (
+	RequestPending   = "pending"
+	RequestAccepted  = "accepted"
+	RequestDeclined  = "declined"
+	RequestCancelled = "cancelled"
+	RequestExpired   = "expired"
+)
+
+const livePendingRequest = "fr.status = 'pending' AND (fr.expires_at IS NULL OR fr.expires_at > datetime())"
+
+var (
+	errFriendNotFound  = errors.New("user not found")
+	errAlreadyFriends  = errors.New("already friends")
+	errRequestPending  = errors.New("friend request already sent")
+	errRequestNotFound = errors.New("friend request not found")
+)
+
+// declineCooldownError is returned when the recipient declined a request
+// from the same sender too recently.
+type declineCooldownError struct {
+	RetryAfter time.Duration
+}
+
+func (e declineCooldownError) Error() string {
+	return "friend request was declined recently"
+}
+
+var (
+	friendRequestConfig     *config.FriendRequestConfig
+	friendRequestConfigOnce sync.Once
+)
+
+func friendRequestsConfig() *config.FriendRequestConfig {
+	friendRequestConfigOnce.Do(func() {
+		friendRequestConfig = config.FriendRequestsConfig()
+	})
+	return friendRequestConfig
+}
+
+type FriendRequestSummary struct {
+	CardSummary
+	Message   string     `json:"message,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// AddFriend sends a friend request, or accepts the target's pending request
+// to the caller if there is one.
+func AddFriend(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+
+	var friendRequest FriendRequest
+	if err := json.NewDecoder(r.Body).Decode(&friendRequest); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	friendRequest.Message = strings.TrimSpace(friendRequest.Message)
+	if friendRequest.UserName == "" || friendRequest.UserName == username {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid username")
+		return
+	}
+	if utf8.RuneCountInString(friendRequest.Message) > maxFriendRequestMessage {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("Message must be at most %d characters", maxFriendRequestMessage))
+		return
+	}
+	cfg := friendRequestsConfig()
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(r.Context())
+
+	accepted, err := session.ExecuteWrite(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		params := map[string]interface{}{
+			"username":        username,
+			"friend_username": friendRequest.UserName,
+		}
+		record, err := singleRecord(transaction, r,
+			"OPTIONAL MATCH (u:User {username: $username}) "+
+				"OPTIONAL MATCH (f:User {username: $friend_username}) "+
+				"RETURN u IS NOT NULL AND f IS NOT NULL AND "+notBlocked("u", "f")+" AS found, "+
+				"exists { (u)-[:FRIENDS_WITH]-(f) } AS friends, "+
+				"exists { MATCH (f)-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest)-[:TO_USER]->(u) WHERE "+livePendingRequest+" } AS incoming, "+
+				"exists { MATCH (u)-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest)-[:TO_USER]->(f) WHERE "+livePendingRequest+" } AS outgoing",
+			params)
+		if err != nil {
+			return nil, err
+		}
+		if found, _ := record.Get("found"); found != true {
+			return nil, errFriendNotFound
+		}
+		if friends, _ := record.Get("friends"); friends == true {
+			return nil, errAlreadyFriends
+		}
+		if incoming, _ := record.Get("incoming"); incoming == true {
+			_, err := respondToFriendRequest(transaction, r, username, friendRequest.UserName, RequestAccepted)
+			return true, err
+		}
+		if outgoing, _ := record.Get("outgoing"); outgoing == true {
+			return nil, errRequestPending
+		}
+
+		record, err = singleRecord(transaction, r,
+			"OPTIONAL MATCH (:User {username: $username})-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest {status: 'declined'})-[:TO_USER]->(:User {username: $friend_username}) "+
+				"RETURN max(fr.responded_at) AS declined_at",
+			params)
+		if err != nil {
+			return nil, err
+		}
+		if value, _ := record.Get("declined_at"); value != nil {
+			if declinedAt, ok := value.(time.Time); ok {
+				if wait := time.Until(declinedAt.Add(cfg.DeclineCooldown)); wait > 0 {
+					return nil, declineCooldownError{RetryAfter: wait}
+				}
+			}
+		}
+
+		params["message"] = friendRequest.Message
+		params["ttl"] = int64(cfg.TTL.Seconds())
+		_, err = transaction.Run(r.Context(),
+			"MATCH (u:User {username: $username})-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest {status: 'pending'})-[:TO_USER]->(f:User {username: $friend_username}) "+
+				"SET fr.status = 'expired'",
+			params)
+		if err != nil {
+			return nil, err
+		}
+		_, err = transaction.Run(r.Context(),
+			"MATCH (u:User {username: $username}), (f:User {username: $friend_username}) "+
+				"CREATE (u)-[:SENT_FRIEND_REQUEST]->(:FriendRequest {status: 'pending', sender: $username, message: $message, "+
+				"created_at: datetime(), expires_at: datetime() + duration({seconds: $ttl})})-[:TO_USER]->(f)",
+			params)
+		return false, err
+	})
+
+	var cooldown declineCooldownError
+	switch {
+	case errors.Is(err, errFriendNotFound):
+		writeJSONMessage(w, http.StatusNotFound, "User not found")
+	case errors.Is(err, errAlreadyFriends):
+		writeJSONMessage(w, http.StatusConflict, "You are already friends")
+	case errors.Is(err, errRequestPending):
+		writeJSONMessage(w, http.StatusConflict, "Friend request already sent")
+	case errors.As(err, &cooldown):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(cooldown.RetryAfter.Seconds()))))
+		writeJSONMessage(w, http.StatusTooManyRequests, "Your last request was declined; try again later")
+	case err != nil:
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to add friend")
+	case accepted == true:
+		writeJSONMessage(w, http.StatusOK, "Friend request accepted")
+	default:
+		writeJSONMessage(w, http.StatusCreated, "Friend request sent")
+	}
+}
+
+// GetPendingFriend lists live incoming requests, newest first. The
+// pending_friends usernames are kept for older clients.
+func GetPendingFriend(w http.ResponseWriter, r *http.Request) {
+	listFriendRequests(w, r,
+		"MATCH (me:User {username: $username})<-[:TO_USER]-(fr:FriendRequest)<-[:SENT_FRIEND_REQUEST]-(other:User) ",
+		"pending_friends")
+}
+
+func GetSentFriendRequests(w http.ResponseWriter, r *http.Request) {
+	listFriendRequests(w, r,
+		"MATCH (me:User {username: $username})-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest)-[:TO_USER]->(other:User) ",
+		"")
+}
+
+func listFriendRequests(w http.ResponseWriter, r *http.Request, match, usernamesKey string) {
+	username := MustPrincipal(r.Context()).Username
+	limit := queryInt(r, "limit", defaultFriendRequestLimit)
+	offset := queryInt(r, "offset", 0)
+	if limit < 1 || limit > maxFriendRequestLimit || offset < 0 {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid limit or offset")
+		return
+	}
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	requests, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := transaction.Run(r.Context(),
+			match+"WHERE "+livePendingRequest+" AND "+notBlocked("me", "other")+" "+
+				"OPTIONAL MATCH (other)-[:HAS_CARD]->(c:Card) "+
+				"RETURN other.username AS username, properties(c) AS card, "+distanceCypher("me", "other")+" AS distance, fr.message AS message, "+
+				"fr.created_at AS created_at, fr.expires_at AS expires_at "+
+				"ORDER BY fr.created_at DESC, username SKIP $offset LIMIT $limit",
+			map[string]interface{}{"username": username, "offset": offset, "limit": limit})
+		if err != nil {
+			return nil, err
+		}
+		records, err := result.Collect(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		requests := []FriendRequestSummary{}
+		for _, record := range records {
+			props := record.AsMap()
+			other, _ := props["username"].(string)
+			card, _ := props["card"].(map[string]interface{})
+			distance, _ := props["distance"].(int64)
+			request := FriendRequestSummary{CardSummary: cardSummary(other, card, int(distance))}
+			request.Message, _ = props["message"].(string)
+			if createdAt, ok := props["created_at"].(time.Time); ok {
+				request.CreatedAt = &createdAt
+			}
+			if expiresAt, ok := props["expires_at"].(time.Time); ok {
+				request.ExpiresAt = &expiresAt
+			}
+			requests = append(requests, request)
+		}
+		return requests, nil
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to retrieve friend requests")
+		return
+	}
+
+	response := map[string]interface{}{"requests": requests}
+	if usernamesKey != "" {
+		usernames := []string{}
+		for _, request := range requests.([]FriendRequestSummary) {
+			usernames = append(usernames, request.Username)
+		}
+		response[usernamesKey] = usernames
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func AcceptFriendRequest(w http.ResponseWriter, r *http.Request) {
+	answerFriendRequest(w, r, RequestAccepted, "Friend request accepted")
+}
+
+func DeclineFriendRequest(w http.ResponseWriter, r *http.Request) {
+	answerFriendRequest(w, r, RequestDeclined, "Friend request declined")
+}
+
+func answerFriendRequest(w http.ResponseWriter, r *http.Request, status, message string) {
+	username := MustPrincipal(r.Context()).Username
+	sender := chi.URLParam(r, "username")
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(r.Context())
+
+	_, err := session.ExecuteWrite(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		return respondToFriendRequest(transaction, r, username, sender, status)
+	})
+	if errors.Is(err, errRequestNotFound) {
+		writeJSONMessage(w, http.StatusNotFound, "No pending friend request from "+sender)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to update friend request")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, message)
+}
+
+// CancelFriendRequest withdraws the caller's pending request to username.
+func CancelFriendRequest(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	recipient := chi.URLParam(r, "username")
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(r.Context())
+
+	_, err := session.ExecuteWrite(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		record, err := singleRecord(transaction, r,
+			"OPTIONAL MATCH (:User {username: $username})-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest)-[:TO_USER]->(:User {username: $recipient}) "+
+				"WHERE "+livePendingRequest+" "+
+				"SET fr.status = $status, fr.responded_at = datetime() "+
+				"RETURN count(fr) AS updated",
+			map[string]interface{}{"username": username, "recipient": recipient, "status": RequestCancelled})
+		if err != nil {
+			return nil, err
+		}
+		if updated, _ := record.Get("updated"); updated == int64(0) {
+			return nil, errRequestNotFound
+		}
+		return nil, nil
+	})
+	if errors.Is(err, errRequestNotFound) {
+		writeJSONMessage(w, http.StatusNotFound, "No pending friend request to "+recipient)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to cancel friend request")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "Friend request cancelled")
+}
+
+// respondToFriendRequest moves sender's live request to username into
+// status, creating the friendship when it is accepted.
+func respondToFriendRequest(transaction neo4j.ManagedTransaction, r *http.Request, username, sender, status string) (interface{}, error) {
+	params := map[string]interface{}{"username": username, "sender": sender, "status": status}
+	record, err := singleRecord(transaction, r,
+		"OPTIONAL MATCH (:User {username: $sender})-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest)-[:TO_USER]->(:User {username: $username}) "+
+			"WHERE "+livePendingRequest+" "+
+			"SET fr.status = $status, fr.responded_at = datetime() "+
+			"RETURN count(fr) AS updated",
+		params)
+	if err != nil {
+		return nil, err
+	}
+	if updated, _ := record.Get("updated"); updated == int64(0) {
+		return nil, errRequestNotFound
+	}
+	if status != RequestAccepted {
+		return nil, nil
+	}
+	_, err = transaction.Run(r.Context(),
+		"MATCH (u:User {username: $username}), (f:User {username: $sender}) "+
+			"MERGE (u)-[a:FRIENDS_WITH]->(f) ON CREATE SET a.since = datetime() "+
+			"MERGE (f)-[b:FRIENDS_WITH]->(u) ON CREATE SET b.since = a.since",
+		params)
+	return nil, err
+}
+
+func singleRecord(transaction neo4j.ManagedTransaction, r *http.Request, query string, params map[string]interface{}) (*neo4j.Record, error) {
+	result, err := transaction.Run(r.Context(), query, params)
+	if err != nil {
+		return nil, err
+	}
+	return result.Single(r.Context())
+}
+
+const friendRequestSweepBatch = 1000
+
+// SweepFriendRequests deletes requests nothing reads any more: expired and
+// answered ones, except declines still inside the cooldown.
+func SweepFriendRequests(ctx context.Context, driver neo4j.DriverWithContext) (int64, error) {
+	session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(ctx)
+
+	params := map[string]interface{}{
+		"cooldown": int64(friendRequestsConfig().DeclineCooldown.Seconds()),
+		"batch":    friendRequestSweepBatch,
+	}
+	var total int64
+	for {
+		deleted, err := session.ExecuteWrite(ctx, func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+			result, err := transaction.Run(ctx,
+				"MATCH (fr:FriendRequest) WHERE NOT ("+livePendingRequest+") "+
+					"AND NOT (fr.status = 'declined' AND coalesce(fr.responded_at, fr.created_at) > datetime() - duration({seconds: $cooldown})) "+
+					"WITH fr LIMIT $batch DETACH DELETE fr RETURN count(*) AS deleted",
+				params)
+			if err != nil {
+				return nil, err
+			}
+			record, err := result.Single(ctx)
+			if err != nil {
+				return nil, err
+			}
+			deleted, _ := record.Get("deleted")
+			return deleted, nil
+		})
+		if err != nil {
+			return total, err
+		}
+		n, _ := deleted.(int64)
+		total += n
+		if n < friendRequestSweepBatch {
+			return total, nil
+		}
+	}
+}
+
+// RunFriendRequestSweeper sweeps friend requests every SweepInterval until
+// ctx is done.
+func RunFriendRequestSweeper(ctx context.Context, driver neo4j.DriverWithContext) {
+	ticker := time.NewTicker(friendRequestsConfig().SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := SweepFriendRequests(ctx, driver); err != nil {
+			log.Printf("friend request sweep: %v", err)
+		} else if n > 0 {
+			log.Printf("friend request sweep: deleted %d requests", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 
@@ -14,151 +13,8 @@ type UserNode struct {
 	UserName string `json:"username"`
 }
 
-func FriendCtx(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		next.ServeHTTP(w, r)
-	})
-}
-
-func GetGraph(w http.ResponseWriter, r *http.Request) {
-	_, exists := CheckLogin(w, r)
-	if !exists {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Not logged in"))
-		return
-	}
-
-	session := database.Neo4jDriver.NewSession(database.Neo4jCtx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
-	defer session.Close(database.Neo4jCtx)
-
-	result, err := session.Run(database.Neo4jCtx, "MATCH (n) OPTIONAL MATCH (n)-[r]-(m) RETURN n, r, m", map[string]interface{}{})
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	var nodes []map[string]interface{}
-	for result.Next(database.Neo4jCtx) {
-		record := result.Record()
-		node, b := record.Get("n")
-
-		if b {
-			nodeProps := node.(neo4j.Node).Props
-			nodes = append(nodes, map[string]interface{}{
-				"type":       "node",
-				"properties": nodeProps,
-			})
-		}
-
-		rel, b := record.Get("r")
-		if b {
-			relProps := rel.(neo4j.Relationship).Props
-			nodes = append(nodes, map[string]interface{}{
-				"type":       "relationship",
-				"properties": relProps,
-			})
-		}
-
-		node, b = record.Get("m")
-		if b {
-			nodeProps := node.(neo4j.Node).Props
-			nodes = append(nodes, map[string]interface{}{
-				"type":       "node",
-				"properties": nodeProps,
-			})
-		}
-	}
-
-	jsonResponse := map[string]interface{}{"graph": nodes}
-	responseBytes, err := json.Marshal(jsonResponse)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to marshal JSON response"}`))
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	_, err = w.Write(responseBytes)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to write JSON response"}`))
-		return
-	}
-}
-
-func GetPendingFriend(w http.ResponseWriter, r *http.Request) {
-	username, exists := CheckLogin(w, r)
-	if !exists {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Not logged in"))
-		return
-	}
-	session := database.Neo4jDriver.NewSession(database.Neo4jCtx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
-	defer session.Close(database.Neo4jCtx)
-
-	result, err := session.ExecuteRead(database.Neo4jCtx, func(transaction neo4j.ManagedTransaction) (any, error) {
-		result, err := transaction.Run(database.Neo4jCtx,
-			"MATCH (u:User)<-[:TO_USER]-(request:FriendRequest{status: 'pending'}) "+
-				"WHERE u.username = $username "+
-				"RETURN DISTINCT request.sender "+
-				"LIMIT 25",
-			map[string]interface{}{
-				"username": username,
-			})
-		fmt.Println("this is result")
-		fmt.Println(result)
-		if err != nil {
-			log.Println(err)
-			http.Error(w, `{"message":"Failed to retrieve pending friend requests"}`, http.StatusInternalServerError)
-		}
-		var friendUsernames []string
-		for result.Next(database.Neo4jCtx) {
-			record := result.Record()
-			fmt.Println(record)
-			friendUsername, b := record.Get("request.sender")
-			if b {
-				friendUsernames = append(friendUsernames, friendUsername.(string))
-			}
-		}
-		fmt.Println(friendUsernames)
-		return friendUsernames, nil
-	})
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to retrieve pending friend requests"}`))
-		return
-	}
-	fmt.Println(result)
-	jsonResponse := map[string][]string{"pending_friends": result.([]string)}
-	responseBytes, err := json.Marshal(jsonResponse)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to marshal JSON response"}`))
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-
-	_, err = w.Write(responseBytes)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to write JSON response"}`))
-		return
-	}
-	w.WriteHeader(http.StatusOK)
-}
-
 func DeleteFriend(w http.ResponseWriter, r *http.Request) {
-	username, exists := CheckLogin(w, r)
-	if !exists {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Not logged in"))
-		return
-	}
+	username := MustPrincipal(r.Context()).Username
 
 	var friendToRemove struct {
 		FriendUsername string `json:"friend_username"`
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+)
+
+const (
+	defaultGraphDepth    = 1
+	maxGraphDepth        = 3
+	defaultGraphPageSize = 100
+	maxGraphPageSize     = 500
+)
+
+// graphRelationshipTypes are the User-to-User relationships GetGraph may
+// traverse. They are stored in both directions and reported once per pair.
+var graphRelationshipTypes = []string{"FRIENDS_WITH"}
+
+type GraphNode struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Distance int    `json:"distance"`
+}
+
+type GraphEdge struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+type GraphPage struct {
+	Nodes      []GraphNode `json:"nodes"`
+	Edges      []GraphEdge `json:"edges"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// graphCursor is the (distance, username) of the last node on a page. Nodes
+// are ordered by that pair.
+type graphCursor struct {
+	Distance int
+	Username string
+}
+
+func (c graphCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(c.Distance) + ":" + c.Username))
+}
+
+func decodeGraphCursor(value string) (graphCursor, error) {
+	if value == "" {
+		return graphCursor{Distance: -1}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return graphCursor{}, err
+	}
+	distance, username, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return graphCursor{}, fmt.Errorf("malformed cursor")
+	}
+	d, err := strconv.Atoi(distance)
+	if err != nil || d < 0 {
+		return graphCursor{}, fmt.Errorf("malformed cursor")
+	}
+	return graphCursor{Distance: d, Username: username}, nil
+}
+
+// GetGraph returns the caller's ego network: the users within depth hops
+// over the requested relationship types, nearest first. Paths never pass
+// through users blocked by or blocking the caller. Each edge is sent
+// with the page holding its later endpoint, so after the last page the
+// client has every edge exactly once and both ends of each.
+//
+// Query parameters: depth (1-3), types (comma separated), limit and cursor.
+func GetGraph(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	query := r.URL.Query()
+
+	depth := queryInt(r, "depth", defaultGraphDepth)
+	if depth < 1 || depth > maxGraphDepth {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("depth must be between 1 and %d", maxGraphDepth))
+		return
+	}
+	limit := queryInt(r, "limit", defaultGraphPageSize)
+	if limit < 1 || limit > maxGraphPageSize {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxGraphPageSize))
+		return
+	}
+	types := graphRelationshipTypes
+	if value := query.Get("types"); value != "" {
+		types = nil
+		for _, t := range strings.Split(value, ",") {
+			t = strings.ToUpper(strings.TrimSpace(t))
+			if !containsString(graphRelationshipTypes, t) {
+				writeJSONMessage(w, http.StatusBadRequest, "Unknown relationship type "+t+"; expected one of "+strings.Join(graphRelationshipTypes, ", "))
+				return
+			}
+			if !containsString(types, t) {
+				types = append(types, t)
+			}
+		}
+	}
+	cursor, err := decodeGraphCursor(query.Get("cursor"))
+	if err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	page, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		return egoGraphPage(transaction, r, username, strings.Join(types, "|"), depth, limit, cursor)
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to load graph")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
+
+// egoGraphPage loads one page in a single query. Distances come from a
+// breadth-first expansion, one DISTINCT level at a time, so each user is
+// reached once instead of once per path. FRIENDS_WITH is undirected, so a
+// level's new users are its neighbours that are not in the two levels before
+// it. The cursor then applies to those levels. relTypes and depth are
+// validated by the caller; Cypher cannot take them as parameters.
+func egoGraphPage(transaction neo4j.ManagedTransaction, r *http.Request, username, relTypes string, depth, limit int, cursor graphCursor) (*GraphPage, error) {
+	var bfs strings.Builder
+	bfs.WriteString("MATCH (me:User {username: $username}) WITH me, [[me]] AS levels ")
+	for i := 0; i < depth; i++ {
+		bfs.WriteString("CALL { WITH me, levels " +
+			"UNWIND last(levels) AS f " +
+			"MATCH (f)-[:" + relTypes + "]-(n:User) WHERE NOT exists { (me)-[:BLOCKED]-(n) } " +
+			"RETURN collect(DISTINCT n) AS candidates } " +
+			"WITH me, levels + [[n IN candidates WHERE NOT n IN last(levels) AND NOT n IN coalesce(levels[-2], [])]] AS levels ")
+	}
+
+	result, err := transaction.Run(r.Context(),
+		bfs.String()+
+			"UNWIND range(0, size(levels) - 1) AS distance "+
+			"UNWIND levels[distance] AS n "+
+			"WITH levels, n, distance "+
+			"WHERE distance > $distance OR (distance = $distance AND n.username > $after) "+
+			"WITH levels, n, distance ORDER BY distance, n.username LIMIT $limit + 1 "+
+			"WITH levels, collect({node: n, distance: distance}) AS page "+
+			"WITH levels, page[..$limit] AS shown, size(page) > $limit AS more "+
+			// Each edge goes with its later endpoint: the neighbour must be
+			// in the level before, or the same level and earlier by name.
+			"CALL { WITH levels, shown "+
+			"UNWIND shown AS entry "+
+			"WITH levels, entry, entry.node AS n "+
+			"MATCH (n)-[rel:"+relTypes+"]-(m:User) "+
+			"WHERE (entry.distance > 0 AND m IN levels[entry.distance - 1]) "+
+			"OR (m.username < n.username AND m IN levels[entry.distance]) "+
+			"RETURN collect(DISTINCT {type: type(rel), "+
+			"source: coalesce(toString(n.member_id), n.username), "+
+			"target: coalesce(toString(m.member_id), m.username)}) AS edges } "+
+			"RETURN [entry IN shown | {username: entry.node.username, "+
+			"id: coalesce(toString(entry.node.member_id), entry.node.username), distance: entry.distance}] AS nodes, "+
+			"more, edges",
+		map[string]interface{}{
+			"username": username,
+			"distance": cursor.Distance,
+			"after":    cursor.Username,
+			"limit":    limit,
+		})
+	if err != nil {
+		return nil, err
+	}
+	records, err := result.Collect(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	page := &GraphPage{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+	if len(records) == 0 {
+		return page, nil
+	}
+	record := records[0]
+	nodes, _ := record.Get("nodes")
+	for _, value := range asList(nodes) {
+		props, _ := value.(map[string]interface{})
+		node := GraphNode{}
+		node.Username, _ = props["username"].(string)
+		node.ID, _ = props["id"].(string)
+		distance, _ := props["distance"].(int64)
+		node.Distance = int(distance)
+		page.Nodes = append(page.Nodes, node)
+	}
+	if more, _ := record.Get("more"); more == true && len(page.Nodes) > 0 {
+		last := page.Nodes[len(page.Nodes)-1]
+		page.NextCursor = graphCursor{Distance: last.Distance, Username: last.Username}.encode()
+	}
+
+	edges, _ := record.Get("edges")
+	seen := map[string]bool{}
+	for _, value := range asList(edges) {
+		props, _ := value.(map[string]interface{})
+		edge := GraphEdge{}
+		edge.Type, _ = props["type"].(string)
+		edge.Source, _ = props["source"].(string)
+		edge.Target, _ = props["target"].(string)
+		if edge.Source > edge.Target {
+			edge.Source, edge.Target = edge.Target, edge.Source
+		}
+		edge.ID = edge.Type + ":" + edge.Source + ":" + edge.Target
+		if !seen[edge.ID] {
+			seen[edge.ID] = true
+			page.Edges = append(page.Edges, edge)
+		}
+	}
+	return page, nil
+}
+
+func asList(value interface{}) []interface{} {
+	list, _ := value.([]interface{})
+	return list
+}
@@ -1,95 +1,210 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/cmd/models"
 	"github.com/petr-discover/config"
+	"github.com/petr-discover/internal"
 )
 
-func CheckLogin(w http.ResponseWriter, r *http.Request) (string, bool) {
+var errAccountSuspended = errors.New("account is suspended")
+
+// CheckLogin resolves the caller from an Authorization: Bearer personal
+// access token, or else from the access token cookie, falling back to the
+// refresh token cookie and re-issuing both cookies when the access token is
+// missing or expired.
+func CheckLogin(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		token, found := strings.CutPrefix(authorization, "Bearer ")
+		if !found {
+			return nil, false
+		}
+		principal, err := checkBearerToken(r, strings.TrimSpace(token))
+		if err != nil {
+			log.Println("Error validating bearer token:", err)
+			return nil, false
+		}
+		return principal, true
+	}
+
 	accessToken, err := r.Cookie("access_token")
-	if err != nil || accessToken == nil {
-		refreshToken, err := r.Cookie("refresh_token")
-		if err == nil && refreshToken != nil {
-			username, exists := checkRefreshToken(refreshToken)
-			if exists {
-				err = handleJWTCookie(w, username)
-				if err != nil {
-					log.Println("Error generating JWT cookie:", err)
-					return "", false
-				}
-			}
-		} else {
-			log.Println("Error retrieving refresh token:", err)
-			return "", false
+	if err == nil && accessToken.Value != "" {
+		principal, err := checkAccessToken(r, accessToken)
+		if err == nil {
+			return principal, true
 		}
-	} else {
-		username, exists := checkAccessToken(accessToken)
-		if !exists {
-			log.Println("Error retrieving access token:", err)
-			return "", false
+		log.Println("Error validating access token:", err)
+	}
+
+	refreshToken, err := r.Cookie("refresh_token")
+	if err != nil || refreshToken.Value == "" {
+		return nil, false
+	}
+	principal, err := checkRefreshToken(w, r, refreshToken)
+	if err != nil {
+		log.Println("Error validating refresh token:", err)
+		return nil, false
+	}
+	return principal, true
+}
+
+// checkRefreshToken rotates the presented refresh token and re-issues both
+// cookies. Refresh is also where account changes are picked up, so the member
+// is reloaded instead of trusting the old claims.
+func checkRefreshToken(w http.ResponseWriter, r *http.Request, tokenVal *http.Cookie) (*Principal, error) {
+	claims, err := internal.ParseJWT(tokenVal.Value, config.JWTSecretKey().RefreshKey)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("unexpected %s token used as refresh token", claims.Purpose)
+	}
+	next, err := database.DBMain.RotateRefreshToken(r.Context(), claims.Id, refreshTokenTTL, refreshReuseGrace)
+	if err != nil {
+		if err == database.ErrRefreshTokenReused {
+			log.Printf("Refresh token reuse for %s, session %s revoked", claims.User, claims.SessionID)
 		}
-		return username, true
+		return nil, err
+	}
+	principal, err := loadPrincipal(r, claims.User)
+	if err != nil {
+		return nil, err
+	}
+	if principal.MemberID != next.MemberID {
+		return nil, fmt.Errorf("refresh token does not belong to %s", claims.User)
 	}
-	return "", false
+	if err := setJWTCookies(w, principal, next); err != nil {
+		return nil, err
+	}
+	return principal, nil
 }
 
-func checkRefreshToken(tokenVal *http.Cookie) (string, bool) {
-	tokenString := tokenVal.Value
-	keys := config.JWTSecretKey()
-	rKey := keys.RefreshKey
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(rKey), nil
-	})
+func checkAccessToken(r *http.Request, tokenVal *http.Cookie) (*Principal, error) {
+	keys, err := internal.AccessKeySet()
 	if err != nil {
-		log.Println("Error decoding JWT:", err)
-		return "", false
+		return nil, err
 	}
-	if tokenVal.Valid() != nil {
-		log.Println("Error validating JWT:", err)
-		return "", false
+	claims, err := keys.Parse(tokenVal.Value)
+	if err != nil {
+		return nil, err
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		log.Println("Error retrieving claims")
-		return "", false
+	active, err := database.DBMain.TouchSession(r.Context(), claims.SessionID)
+	if err != nil {
+		return nil, err
 	}
-	log.Println("Claims found in JWT:", claims)
-	username, exists := claims["user"].(string)
-	if !exists {
-		log.Println("User claim not found in JWT")
-		return "", false
+	if !active {
+		return nil, fmt.Errorf("session %s has been revoked", claims.SessionID)
 	}
-	return username, exists
+	return &Principal{
+		Username:  claims.User,
+		MemberID:  claims.MemberID,
+		Roles:     claims.Roles,
+		SessionID: claims.SessionID,
+	}, nil
 }
 
-func checkAccessToken(tokenVal *http.Cookie) (string, bool) {
-	tokenString := tokenVal.Value
-	keys := config.JWTSecretKey()
-	aKey := keys.SecretKey
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(aKey), nil
-	})
+// loadPrincipal reads the member's current roles and refuses suspended
+// accounts, so every login and refresh picks up role and status changes.
+func loadPrincipal(r *http.Request, username string) (*Principal, error) {
+	id, roles, status, found, err := database.DBMain.MemberAccess(r.Context(), username)
 	if err != nil {
-		log.Println("Error decoding JWT:", err)
-		return "", false
+		return nil, fmt.Errorf("database error: %s", err.Error())
+	}
+	if !found {
+		return nil, fmt.Errorf("user %s not found", username)
 	}
-	if tokenVal.Valid() != nil {
-		log.Println("Error validating JWT:", err)
-		return "", false
+	if status != models.MemberActive {
+		return nil, errAccountSuspended
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		log.Println("Error retrieving claims")
-		return "", false
+	if len(roles) == 0 {
+		roles = []string{RoleUser}
 	}
-	log.Println("Claims found in JWT:", claims)
-	username, exists := claims["user"].(string)
-	if !exists {
-		log.Println("User claim not found in JWT")
-		return "", false
+	return &Principal{Username: username, MemberID: id, Roles: roles}, nil
+}
+
+var (
+	trustedProxies     []*net.IPNet
+	trustedProxiesOnce sync.Once
+)
+
+func trustedProxyNets() []*net.IPNet {
+	trustedProxiesOnce.Do(func() {
+		for _, proxy := range config.TrustedProxies() {
+			if !strings.Contains(proxy, "/") {
+				if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+					proxy += "/32"
+				} else {
+					proxy += "/128"
+				}
+			}
+			_, network, err := net.ParseCIDR(proxy)
+			if err != nil {
+				log.Printf("Ignoring invalid TRUSTED_PROXIES entry %q", proxy)
+				continue
+			}
+			trustedProxies = append(trustedProxies, network)
+		}
+	})
+	return trustedProxies
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxyNets() {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIP replaces RemoteAddr with the client address from X-Forwarded-For or
+// X-Real-IP, but only for requests relayed by a proxy in TRUSTED_PROXIES;
+// anyone else could put any address there. X-Forwarded-For is read from the
+// right, skipping our own proxies, so entries the client made up are ignored.
+func RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isTrustedProxy(net.ParseIP(clientIP(r))) {
+			if ip := forwardedClientIP(r); ip != "" {
+				r.RemoteAddr = ip
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func forwardedClientIP(r *http.Request) string {
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return ""
+			}
+			if i == 0 || !isTrustedProxy(ip) {
+				return ip.String()
+			}
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// clientIP returns the caller address; RealIP has already replaced
+// RemoteAddr with the forwarded address for requests from trusted proxies.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-	return username, exists
+	return host
 }
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIPOnlyTrustsConfiguredProxies(t *testing.T) {
+	trustedProxiesOnce.Do(func() {})
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	previous := trustedProxies
+	trustedProxies = []*net.IPNet{network}
+	t.Cleanup(func() { trustedProxies = previous })
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"spoofed header from untrusted peer", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:443", "198.51.100.1", "", "198.51.100.1"},
+		{"client-supplied hops are skipped", "10.0.0.2:443", "1.2.3.4, 198.51.100.1, 10.0.0.3", "", "198.51.100.1"},
+		{"real ip from trusted proxy", "10.0.0.2:443", "", "198.51.100.1", "198.51.100.1"},
+		{"garbage from trusted proxy", "10.0.0.2:443", "not-an-ip", "", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			var got string
+			RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/config"
+	"github.com/petr-discover/internal"
+)
+
+var (
+	limiter     *internal.LoginLimiter
+	limiterOnce sync.Once
+)
+
+// loginLimiter returns the limiter selected by LOGIN_LIMIT_BACKEND: "memory"
+// for a single instance, "postgres" to share state between instances.
+func loginLimiter() *internal.LoginLimiter {
+	limiterOnce.Do(func() {
+		cfg := config.LoginLimiterConfig()
+		var store internal.AttemptStore
+		switch cfg.Backend {
+		case "postgres":
+			store = database.LoginAttemptStore{DB: database.DBMain}
+		default:
+			if cfg.Backend != "memory" {
+				log.Printf("Unknown LOGIN_LIMIT_BACKEND %q, using memory", cfg.Backend)
+			}
+			store = internal.NewMemoryAttemptStore(cfg.FailureWindow + cfg.LockoutDuration)
+		}
+		limiter = internal.NewLoginLimiter(store, cfg)
+	})
+	return limiter
+}
+
+// loginAccount is the lockout account for a login by email: the member's
+// username, so that guesses by username and by email share one counter.
+// Unknown addresses are counted under the address itself.
+func loginAccount(r *http.Request, email string) string {
+	if email == "" {
+		return ""
+	}
+	_, username, _, found, err := database.DBMain.MemberByEmail(r.Context(), email)
+	if err != nil {
+		log.Println("Login limiter:", err)
+	}
+	if found {
+		return username
+	}
+	return email
+}
+
+// allowLoginAttempt answers 429 with Retry-After when account or the
+// caller's IP is being throttled. Limiter errors fail open so an outage of
+// the shared store does not lock everyone out.
+func allowLoginAttempt(w http.ResponseWriter, r *http.Request, account string) bool {
+	wait, err := loginLimiter().Allow(r.Context(), account, clientIP(r), time.Now())
+	if err != nil {
+		log.Println("Login limiter:", err)
+		return true
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeJSONMessage(w, http.StatusTooManyRequests, "Too many failed attempts, try again later")
+		return false
+	}
+	return true
+}
+
+func recordLoginFailure(r *http.Request, account string) {
+	locked, err := loginLimiter().Failure(r.Context(), account, clientIP(r), time.Now())
+	if err != nil {
+		log.Println("Login limiter:", err)
+	}
+	for _, state := range locked {
+		err := database.DBMain.RecordAudit(r.Context(), database.AuditEntry{
+			Actor:      "system",
+			Action:     "auth.lockout",
+			TargetType: "login_key",
+			TargetID:   state.Key,
+			Details: map[string]interface{}{
+				"failures":      state.Failures,
+				"blocked_until": state.BlockedUntil,
+			},
+			IP: clientIP(r),
+		})
+		if err != nil {
+			log.Println("Failed to record audit entry: auth.lockout", err)
+		}
+	}
+}
+
+func recordLoginSuccess(r *http.Request, account string) {
+	if err := loginLimiter().Success(r.Context(), account); err != nil {
+		log.Println("Login limiter:", err)
+	}
+}
+
+func AdminListLockouts(w http.ResponseWriter, r *http.Request) {
+	states, err := loginLimiter().Active(r.Context(), time.Now())
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to list lockouts")
+		return
+	}
+	recordAudit(r, "lockouts.list", "", "", nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"lockouts": states})
+}
+
+// AdminClearLockout takes the full key, e.g. account:alice or ip:10.0.0.1.
+func AdminClearLockout(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if err := loginLimiter().Store.Delete(r.Context(), key); err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to clear lockout")
+		return
+	}
+	recordAudit(r, "lockouts.clear", "login_key", key, nil)
+	writeJSONMessage(w, http.StatusOK, "Lockout cleared")
+}
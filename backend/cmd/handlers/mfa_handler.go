@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/config"
+	"github.com/petr-discover/internal"
+)
+
+const (
+	mfaPendingPurpose  = "mfa_pending"
+	mfaPendingTTL      = 5 * time.Minute
+	recoveryCodeCount  = 10
+	totpIssuer         = "petr-discover"
+	mfaInvalidCodeText = "Invalid two-factor code"
+)
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// writeMFAChallenge answers a successful first login step with a short-lived
+// mfa_pending token when principal has confirmed TOTP enrollment. It reports
+// whether the challenge was written; if not, the caller issues cookies.
+func writeMFAChallenge(w http.ResponseWriter, r *http.Request, principal *Principal) (bool, error) {
+	state, err := database.DBMain.GetMFA(r.Context(), principal.MemberID)
+	if err != nil {
+		return false, err
+	}
+	if state == nil || !state.Confirmed {
+		return false, nil
+	}
+
+	token, err := internal.GenerateJWT(internal.Claims{
+		User:     principal.Username,
+		MemberID: principal.MemberID,
+		Purpose:  mfaPendingPurpose,
+	}, config.JWTSecretKey().RefreshKey, mfaPendingTTL)
+	if err != nil {
+		return false, err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"expires_in":   int(mfaPendingTTL.Seconds()),
+	})
+	return true, nil
+}
+
+// VerifyMFA is the second login step: it exchanges an mfa_pending token and a
+// TOTP or recovery code for the real session cookies.
+func VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var verifyRequest MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&verifyRequest); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	claims, err := internal.ParseJWT(verifyRequest.MFAToken, config.JWTSecretKey().RefreshKey)
+	if err != nil || claims.Purpose != mfaPendingPurpose {
+		writeJSONMessage(w, http.StatusUnauthorized, "MFA token is invalid or expired")
+		return
+	}
+
+	principal, err := loadPrincipal(r, claims.User)
+	if err == errAccountSuspended {
+		writeJSONMessage(w, http.StatusForbidden, "Account is suspended")
+		return
+	}
+	if err != nil || principal.MemberID != claims.MemberID {
+		writeJSONMessage(w, http.StatusUnauthorized, "MFA token is invalid or expired")
+		return
+	}
+
+	if !allowLoginAttempt(w, r, principal.Username) {
+		return
+	}
+	ok, err := checkSecondFactor(r, principal.MemberID, verifyRequest.Code, verifyRequest.RecoveryCode)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to verify two-factor code")
+		return
+	}
+	if !ok {
+		recordLoginFailure(r, principal.Username)
+		writeJSONMessage(w, http.StatusUnauthorized, mfaInvalidCodeText)
+		return
+	}
+	recordLoginSuccess(r, principal.Username)
+
+	if err := handleJWTCookie(w, r, principal); err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Error generating JWT cookie")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "success")
+}
+
+func EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+
+	secret, err := internal.GenerateTOTPSecret()
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+	started, err := database.DBMain.StartMFAEnrollment(r.Context(), principal.MemberID, secret)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+	if !started {
+		writeJSONMessage(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":           secret,
+		"provisioning_uri": internal.TOTPProvisioningURI(totpIssuer, principal.Username, secret),
+	})
+}
+
+func ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+
+	var codeRequest MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&codeRequest); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	state, err := database.DBMain.GetMFA(r.Context(), principal.MemberID)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to confirm enrollment")
+		return
+	}
+	if state == nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Start enrollment first")
+		return
+	}
+	if state.Confirmed {
+		writeJSONMessage(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	step, ok := internal.ValidateTOTP(state.Secret, codeRequest.Code, time.Now())
+	if !ok {
+		writeJSONMessage(w, http.StatusBadRequest, mfaInvalidCodeText)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to confirm enrollment")
+		return
+	}
+	if err := database.DBMain.ConfirmMFA(r.Context(), principal.MemberID, step, hashes); err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to confirm enrollment")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	if !requireSecondFactor(w, r, principal) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = database.DBMain.RegenerateRecoveryCodes(r.Context(), principal.MemberID, hashes)
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to regenerate recovery codes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"recovery_codes": codes})
+}
+
+func DisableMFA(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	if !requireSecondFactor(w, r, principal) {
+		return
+	}
+
+	if err := database.DBMain.DisableMFA(r.Context(), principal.MemberID); err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "Two-factor authentication disabled")
+}
+
+// requireSecondFactor guards changes to an existing enrollment with a fresh
+// TOTP or recovery code from the request body.
+func requireSecondFactor(w http.ResponseWriter, r *http.Request, principal *Principal) bool {
+	var verifyRequest MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&verifyRequest); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return false
+	}
+	ok, err := checkSecondFactor(r, principal.MemberID, verifyRequest.Code, verifyRequest.RecoveryCode)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to verify two-factor code")
+		return false
+	}
+	if !ok {
+		writeJSONMessage(w, http.StatusUnauthorized, mfaInvalidCodeText)
+		return false
+	}
+	return true
+}
+
+func checkSecondFactor(r *http.Request, memberID int64, code, recoveryCode string) (bool, error) {
+	state, err := database.DBMain.GetMFA(r.Context(), memberID)
+	if err != nil {
+		return false, err
+	}
+	if state == nil || !state.Confirmed {
+		return false, nil
+	}
+
+	if recoveryCode != "" {
+		return database.DBMain.UseRecoveryCode(r.Context(), memberID, internal.HashToken(internal.NormalizeRecoveryCode(recoveryCode)))
+	}
+	step, ok := internal.ValidateTOTP(state.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return database.DBMain.UseTOTPStep(r.Context(), memberID, step)
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := internal.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate recovery codes: %s", err.Error())
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = internal.HashToken(internal.NormalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
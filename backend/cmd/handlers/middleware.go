@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Principal is the authenticated caller. Requests made with a personal
+// access token carry its TokenID and Scopes instead of a SessionID.
+type Principal struct {
+	Username    string
+	MemberID    int64
+	Roles       []string
+	SessionID   string
+	TokenID     int64
+	TokenPrefix string
+	Scopes      []string
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope is always true for cookie sessions, which act with the member's
+// full rights.
+func (p *Principal) HasScope(scope string) bool {
+	return p.TokenID == 0 || containsString(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// MustPrincipal is for handlers mounted behind RequireAuth.
+func MustPrincipal(ctx context.Context) *Principal {
+	principal, ok := PrincipalFrom(ctx)
+	if !ok {
+		panic("handlers: no principal in context; is the route behind RequireAuth?")
+	}
+	return principal
+}
+
+// Authenticate puts the caller's Principal into the request context when the
+// request carries valid credentials. It never rejects a request; pair it with
+// RequireAuth or RequireRole for that.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := CheckLogin(w, r); ok {
+			r = r.WithContext(WithPrincipal(r.Context(), principal))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAuth admits cookie sessions only; routes that personal access
+// tokens may call use RequireScope instead.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFrom(r.Context())
+		if !ok {
+			writeJSONMessage(w, http.StatusUnauthorized, "Not logged in")
+			return
+		}
+		if principal.TokenID != 0 {
+			writeJSONMessage(w, http.StatusForbidden, "Personal access tokens cannot be used here")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFrom(r.Context())
+			if !ok {
+				writeJSONMessage(w, http.StatusUnauthorized, "Not logged in")
+				return
+			}
+			if !principal.HasScope(scope) {
+				writeJSONMessage(w, http.StatusForbidden, "Token is missing the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFrom(r.Context())
+			if !ok {
+				writeJSONMessage(w, http.StatusUnauthorized, "Not logged in")
+				return
+			}
+			if principal.TokenID != 0 {
+				writeJSONMessage(w, http.StatusForbidden, "Personal access tokens cannot be used here")
+				return
+			}
+			for _, role := range roles {
+				if principal.HasRole(role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			writeJSONMessage(w, http.StatusForbidden, "Forbidden")
+		})
+	}
+}
+
+func writeJSONMessage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
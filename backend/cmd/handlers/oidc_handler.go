@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/config"
+	"github.com/petr-discover/internal"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcFlowTTL       = 600
+	oidcFlowLogin     = "login"
+	oidcFlowLink      = "link"
+	oidcSignupPurpose = "oidc_signup"
+	oidcSignupTTL     = 10 * time.Minute
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,50}$`)
+
+type OIDCSignupRequest struct {
+	SignupToken string `json:"signup_token"`
+	Username    string `json:"username"`
+}
+
+// OIDCLogin redirects to the provider named in the path. State, nonce and the
+// PKCE verifier ride along in a short-lived cookie scoped to that provider's
+// callback.
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	startOIDCFlow(w, r, oidcFlowLogin)
+}
+
+// OIDCLink starts the same flow for a signed-in member; the callback then
+// attaches the identity to that member instead of logging in.
+func OIDCLink(w http.ResponseWriter, r *http.Request) {
+	startOIDCFlow(w, r, oidcFlowLink)
+}
+
+func startOIDCFlow(w http.ResponseWriter, r *http.Request, mode string) {
+	provider, ok := oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	state, _, err := internal.NewOpaqueToken()
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	nonce, _, err := internal.NewOpaqueToken()
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie(provider.Name),
+		Value:    strings.Join([]string{state, nonce, verifier, mode}, "."),
+		MaxAge:   oidcFlowTTL,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/api/v1/auth/" + provider.Name,
+	})
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	cookie, err := r.Cookie(oidcFlowCookie(provider.Name))
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie(provider.Name),
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Path:     "/api/v1/auth/" + provider.Name,
+	})
+	if err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Login session expired, start again")
+		return
+	}
+	flow := strings.Split(cookie.Value, ".")
+	if len(flow) != 4 {
+		writeJSONMessage(w, http.StatusBadRequest, "Login session expired, start again")
+		return
+	}
+	state, nonce, verifier, mode := flow[0], flow[1], flow[2], flow[3]
+
+	if errCode := r.FormValue("error"); errCode != "" {
+		log.Printf("%s login failed: %s %s\n", provider.Name, errCode, r.FormValue("error_description"))
+		writeJSONMessage(w, http.StatusUnauthorized, "Login was rejected by the identity provider")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(state)) != 1 {
+		log.Printf("Invalid %s oauth state\n", provider.Name)
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid login state")
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), r.FormValue("code"), verifier, nonce)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusUnauthorized, "Failed to verify identity")
+		return
+	}
+
+	if mode == oidcFlowLink {
+		linkOIDCIdentity(w, r, identity)
+		return
+	}
+
+	memberID, username, found, err := database.DBMain.LoginIdentity(r.Context(), identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to look up identity")
+		return
+	}
+	if !found {
+		username, ok = signUpOIDCIdentity(w, r, identity)
+		if !ok {
+			return
+		}
+	}
+
+	principal, err := loadPrincipal(r, username)
+	if err == nil && found && principal.MemberID != memberID {
+		err = fmt.Errorf("identity %s/%s resolved to member %d, not %d", identity.Provider, identity.Subject, memberID, principal.MemberID)
+	}
+	completeOIDCLogin(w, r, principal, err)
+}
+
+// CompleteOIDCSignup finishes a signup that stopped because the username
+// derived from the identity was already taken.
+func CompleteOIDCSignup(w http.ResponseWriter, r *http.Request) {
+	var signupRequest OIDCSignupRequest
+	if err := json.NewDecoder(r.Body).Decode(&signupRequest); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	claims, err := internal.ParseJWT(signupRequest.SignupToken, config.JWTSecretKey().RefreshKey)
+	if err != nil || claims.Purpose != oidcSignupPurpose || claims.Provider != chi.URLParam(r, "provider") {
+		writeJSONMessage(w, http.StatusUnauthorized, "Signup token is invalid or expired")
+		return
+	}
+	if !usernamePattern.MatchString(signupRequest.Username) {
+		writeJSONMessage(w, http.StatusBadRequest, "Username must be 3-50 letters, digits, '.', '_' or '-'")
+		return
+	}
+
+	identity := &internal.OIDCIdentity{
+		Provider:      claims.Provider,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}
+	taken, err := database.DBMain.UsernameTaken(r.Context(), signupRequest.Username)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to create account")
+		return
+	}
+	if taken {
+		writeUsernameRequired(w, r, identity)
+		return
+	}
+
+	if _, err := createOIDCMember(r, identity, signupRequest.Username); err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to create account")
+		return
+	}
+	principal, err := loadPrincipal(r, signupRequest.Username)
+	completeOIDCLogin(w, r, principal, err)
+}
+
+func ListIdentities(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	identities, err := database.DBMain.ListIdentities(r.Context(), principal.MemberID)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to list identities")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(identities)
+}
+
+func UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	provider := chi.URLParam(r, "provider")
+
+	removed, err := database.DBMain.UnlinkIdentity(r.Context(), principal.MemberID, provider)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to unlink identity")
+		return
+	}
+	if !removed {
+		writeJSONMessage(w, http.StatusConflict, "Identity is not linked, or it is your only way to sign in; verify your email first")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, provider+" account unlinked")
+}
+
+func linkOIDCIdentity(w http.ResponseWriter, r *http.Request, identity *internal.OIDCIdentity) {
+	principal, ok := PrincipalFrom(r.Context())
+	if !ok || principal.TokenID != 0 {
+		writeJSONMessage(w, http.StatusUnauthorized, "Log in before linking an account")
+		return
+	}
+
+	err := database.DBMain.LinkIdentity(r.Context(), principal.MemberID, identity.Provider, identity.Subject, identity.Email)
+	switch err {
+	case nil:
+		writeJSONMessage(w, http.StatusOK, identity.Provider+" account linked")
+	case database.ErrIdentityLinked:
+		writeJSONMessage(w, http.StatusConflict, "This "+identity.Provider+" account is linked to another user")
+	case database.ErrProviderLinked:
+		writeJSONMessage(w, http.StatusConflict, "Another "+identity.Provider+" account is already linked; unlink it first")
+	default:
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to link account")
+	}
+}
+
+// signUpOIDCIdentity handles the first login with an unlinked identity. An
+// account with the same address is linked only when both sides have verified
+// it; otherwise the owner has to sign in and link explicitly. New members
+// get a username derived from the identity, or are asked to pick one.
+func signUpOIDCIdentity(w http.ResponseWriter, r *http.Request, identity *internal.OIDCIdentity) (string, bool) {
+	if identity.Email == "" {
+		writeJSONMessage(w, http.StatusBadRequest, "Identity provider did not share an email address")
+		return "", false
+	}
+
+	memberID, username, verified, found, err := database.DBMain.MemberByEmail(r.Context(), identity.Email)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to look up account")
+		return "", false
+	}
+	if found {
+		if !verified || !identity.EmailVerified {
+			writeJSONMessage(w, http.StatusConflict,
+				"An account with this email already exists; log in and link "+identity.Provider+" from your account")
+			return "", false
+		}
+		err = database.DBMain.LinkIdentity(r.Context(), memberID, identity.Provider, identity.Subject, identity.Email)
+		if err == database.ErrProviderLinked {
+			writeJSONMessage(w, http.StatusConflict, "This account is linked to a different "+identity.Provider+" user")
+			return "", false
+		}
+		if err != nil {
+			log.Println(err)
+			writeJSONMessage(w, http.StatusInternalServerError, "Failed to link account")
+			return "", false
+		}
+		return username, true
+	}
+
+	username = usernameBase(identity)
+	taken, err := database.DBMain.UsernameTaken(r.Context(), username)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to create account")
+		return "", false
+	}
+	if taken {
+		writeUsernameRequired(w, r, identity)
+		return "", false
+	}
+
+	if _, err := createOIDCMember(r, identity, username); err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to create account")
+		return "", false
+	}
+	return username, true
+}
+
+func createOIDCMember(r *http.Request, identity *internal.OIDCIdentity, username string) (int64, error) {
+	// The member signs in through the identity; the random password only
+	// becomes usable through a password reset.
+	password, _, err := internal.NewOpaqueToken()
+	if err != nil {
+		return 0, err
+	}
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	return database.DBMain.CreateMemberWithIdentity(r.Context(), username, hashedPassword, identity.Email,
+		identity.EmailVerified, identity.Provider, identity.Subject)
+}
+
+// writeUsernameRequired answers with a signup token for CompleteOIDCSignup and
+// a free username the client can offer as the default.
+func writeUsernameRequired(w http.ResponseWriter, r *http.Request, identity *internal.OIDCIdentity) {
+	claims := internal.Claims{
+		Purpose:       oidcSignupPurpose,
+		Provider:      identity.Provider,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+	}
+	claims.Subject = identity.Subject
+	token, err := internal.GenerateJWT(claims, config.JWTSecretKey().RefreshKey, oidcSignupTTL)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to create account")
+		return
+	}
+
+	base := usernameBase(identity)
+	if len(base) > 46 {
+		base = base[:46]
+	}
+	var suggestion string
+	for i := 2; i < 100 && suggestion == ""; i++ {
+		candidate := fmt.Sprintf("%s%d", base, i)
+		taken, err := database.DBMain.UsernameTaken(r.Context(), candidate)
+		if err != nil {
+			log.Println(err)
+			break
+		}
+		if !taken {
+			suggestion = candidate
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"username_required":  true,
+		"message":            "Username is taken, choose another one",
+		"suggested_username": suggestion,
+		"signup_token":       token,
+		"expires_in":         int(oidcSignupTTL.Seconds()),
+	})
+}
+
+func completeOIDCLogin(w http.ResponseWriter, r *http.Request, principal *Principal, err error) {
+	if err == nil {
+		var challenged bool
+		challenged, err = writeMFAChallenge(w, r, principal)
+		if challenged {
+			return
+		}
+	}
+	if err == nil {
+		err = handleJWTCookie(w, r, principal)
+	}
+	if err == errAccountSuspended {
+		writeJSONMessage(w, http.StatusForbidden, "Account is suspended")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Error generating JWT cookie")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "success")
+}
+
+// usernameBase derives a username from preferred_username or the local part
+// of the email, dropping characters usernames cannot contain.
+func usernameBase(identity *internal.OIDCIdentity) string {
+	source := identity.PreferredUsername
+	if source == "" {
+		source = identity.Email
+	}
+	source = strings.SplitN(source, "@", 2)[0]
+
+	var b strings.Builder
+	for _, c := range source {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._-", c) {
+			b.WriteRune(c)
+		}
+	}
+	username := b.String()
+	if len(username) > 50 {
+		username = username[:50]
+	}
+	for len(username) < 3 {
+		username += "_"
+	}
+	return username
+}
+
+func oidcProvider(w http.ResponseWriter, r *http.Request) (*internal.OIDCProvider, bool) {
+	provider, err := internal.OIDCProviderByName(chi.URLParam(r, "provider"))
+	if err == internal.ErrUnknownOIDCProvider {
+		writeJSONMessage(w, http.StatusNotFound, "Unknown identity provider")
+		return nil, false
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Identity providers are misconfigured")
+		return nil, false
+	}
+	return provider, true
+}
+
+func oidcFlowCookie(provider string) string {
+	return "oidc_" + provider
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt"
+	"github.com/petr-discover/config"
+	"github.com/petr-discover/internal"
+	"github.com/petr-discover/internal/oidctest"
+)
+
+// oidcFlow is one login or link attempt against the mock provider, up to
+// the redirect back to the callback.
+type oidcFlow struct {
+	router http.Handler
+	cookie *http.Cookie
+	state  string
+	code   string
+}
+
+func setupOIDC(t *testing.T) (*oidctest.Server, http.Handler) {
+	t.Helper()
+	server, err := oidctest.NewServer("petr-discover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	internal.SetOIDCProviders(internal.NewOIDCProvider(config.OIDCProviderConfig{
+		Name:        "mock",
+		Issuer:      server.Issuer(),
+		ClientID:    "petr-discover",
+		RedirectURL: "http://localhost:8080/api/v1/auth/mock/callback",
+		Scopes:      []string{"openid", "email"},
+	}, server.Client()))
+
+	router := chi.NewRouter()
+	router.Get("/api/v1/auth/{provider}/login", OIDCLogin)
+	router.Get("/api/v1/auth/{provider}/link", OIDCLink)
+	router.Get("/api/v1/auth/{provider}/callback", OIDCCallback)
+	return server, router
+}
+
+// beginOIDCFlow follows the start endpoint to the provider, which approves
+// with claims.
+func beginOIDCFlow(t *testing.T, server *oidctest.Server, router http.Handler, start string, principal *Principal, claims jwt.MapClaims) oidcFlow {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, start, nil)
+	if principal != nil {
+		r = r.WithContext(WithPrincipal(r.Context(), principal))
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("start: status %d, body %s", w.Code, w.Body)
+	}
+
+	flow := oidcFlow{router: router}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oidcFlowCookie("mock") {
+			flow.cookie = cookie
+		}
+	}
+	if flow.cookie == nil {
+		t.Fatal("start did not set the flow cookie")
+	}
+	location := w.Header().Get("Location")
+	authURL, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow.state = authURL.Query().Get("state")
+	flow.code, err = server.Authorize(location, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return flow
+}
+
+func (f oidcFlow) callback(principal *Principal, state string) *httptest.ResponseRecorder {
+	query := url.Values{"state": {state}, "code": {f.code}}
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/auth/mock/callback?"+query.Encode(), nil)
+	r.AddCookie(&http.Cookie{Name: f.cookie.Name, Value: f.cookie.Value})
+	if principal != nil {
+		r = r.WithContext(WithPrincipal(r.Context(), principal))
+	}
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, r)
+	return w
+}
+
+func TestOIDCLinkAttachesIdentityToSignedInMember(t *testing.T) {
+	mock := setupMockDB(t)
+	server, router := setupOIDC(t)
+	principal := &Principal{Username: "petr", MemberID: 7}
+
+	flow := beginOIDCFlow(t, server, router, "/api/v1/auth/mock/link", principal,
+		jwt.MapClaims{"sub": "subject-1", "email": "petr@example.com"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT member_id FROM member_identities")).
+		WithArgs("mock", "subject-1").
+		WillReturnRows(sqlmock.NewRows([]string{"member_id"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM member_identities")).
+		WithArgs(7, "mock").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO member_identities")).
+		WithArgs("mock", "subject-1", 7, "petr@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	w := flow.callback(principal, flow.state)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLinkRefusesIdentityOfAnotherMember(t *testing.T) {
+	mock := setupMockDB(t)
+	server, router := setupOIDC(t)
+	principal := &Principal{Username: "petr", MemberID: 7}
+
+	flow := beginOIDCFlow(t, server, router, "/api/v1/auth/mock/link", principal,
+		jwt.MapClaims{"sub": "subject-1", "email": "petr@example.com"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT member_id FROM member_identities")).
+		WithArgs("mock", "subject-1").
+		WillReturnRows(sqlmock.NewRows([]string{"member_id"}).AddRow(99))
+	mock.ExpectRollback()
+
+	w := flow.callback(principal, flow.state)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLoginDoesNotLinkUnverifiedAccount(t *testing.T) {
+	mock := setupMockDB(t)
+	server, router := setupOIDC(t)
+
+	flow := beginOIDCFlow(t, server, router, "/api/v1/auth/mock/login", nil,
+		jwt.MapClaims{"sub": "subject-1", "email": "petr@example.com", "email_verified": true})
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE member_identities i SET last_login_at")).
+		WithArgs("mock", "subject-1", "petr@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, username, email_verified_at IS NOT NULL FROM member")).
+		WithArgs("petr@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "verified"}).AddRow(7, "petr", false))
+
+	w := flow.callback(nil, flow.state)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCCallbackRejectsForeignState(t *testing.T) {
+	mock := setupMockDB(t)
+	server, router := setupOIDC(t)
+
+	flow := beginOIDCFlow(t, server, router, "/api/v1/auth/mock/login", nil,
+		jwt.MapClaims{"sub": "subject-1", "email": "petr@example.com"})
+
+	w := flow.callback(nil, "attacker-state")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/config"
+	"github.com/petr-discover/internal"
+)
+
+const minPasswordLength = 8
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword answers the same way whether or not the email is registered
+// so it cannot be used to enumerate accounts.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var forgotRequest ForgotPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&forgotRequest)
+	if err != nil || forgotRequest.Email == "" {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := sendPasswordReset(r, forgotRequest.Email); err != nil {
+		log.Println(err)
+	}
+	writeJSONMessage(w, http.StatusOK, "If the email is registered, a reset link has been sent")
+}
+
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var resetRequest ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&resetRequest)
+	if err != nil || resetRequest.Token == "" {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(resetRequest.Password) < minPasswordLength {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("Password must be at least %d characters", minPasswordLength))
+		return
+	}
+
+	hashedPassword, err := HashPassword(resetRequest.Password)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	_, err = database.DBMain.ResetPassword(r.Context(), internal.HashToken(resetRequest.Token), hashedPassword)
+	if err == database.ErrResetTokenInvalid {
+		writeJSONMessage(w, http.StatusBadRequest, "Reset token is invalid or expired")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	clearJWTCookies(w)
+	writeJSONMessage(w, http.StatusOK, "Password has been reset")
+}
+
+func sendPasswordReset(r *http.Request, email string) error {
+	var memberID int64
+	err := database.DBMain.QueryRowContext(r.Context(), "SELECT id FROM member WHERE email = $1", email).Scan(&memberID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, tokenHash, err := internal.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	ttl := config.PasswordResetTTL()
+	if err := database.DBMain.CreatePasswordResetToken(r.Context(), memberID, tokenHash, ttl); err != nil {
+		return err
+	}
+
+	mailer, err := internal.DefaultMailer()
+	if err != nil {
+		return err
+	}
+	link := config.AppBaseURL() + "/reset-password?token=" + url.QueryEscape(token)
+	return mailer.Send(r.Context(), internal.Mail{
+		To:      email,
+		Subject: "Reset your petr-discover password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your petr-discover account.\n\n"+
+			"Use this link within %d minutes to choose a new password:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.", int(ttl.Minutes()), link),
+	})
+}
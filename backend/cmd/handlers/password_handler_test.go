@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/internal"
+)
+
+// capture is a sqlmock argument that records the value it is matched with.
+type capture struct {
+	value string
+}
+
+func (c *capture) Match(v driver.Value) bool {
+	c.value, _ = v.(string)
+	return c.value != ""
+}
+
+// sameAs matches the value an earlier capture recorded.
+type sameAs struct {
+	c *capture
+}
+
+func (s sameAs) Match(v driver.Value) bool {
+	value, _ := v.(string)
+	return value == s.c.value
+}
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DBMain
+	database.DBMain = &database.DB{DB: db}
+	t.Cleanup(func() {
+		database.DBMain = previous
+		db.Close()
+	})
+	return mock
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	mock := setupMockDB(t)
+	mailer := &internal.MemoryMailer{}
+	internal.SetMailer(mailer)
+
+	const memberID = 42
+	tokenHash := &capture{}
+
+	// Forgot password: the member is found and a reset token stored.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM member WHERE email = $1")).
+		WithArgs("petr@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(memberID))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE password_reset_token SET used_at")).
+		WithArgs(memberID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO password_reset_token")).
+		WithArgs(memberID, tokenHash, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	w := httptest.NewRecorder()
+	ForgotPassword(w, httptest.NewRequest(http.MethodPost, "/api/v1/auth/password/forgot",
+		strings.NewReader(`{"email":"petr@example.com"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("forgot: status %d, body %s", w.Code, w.Body)
+	}
+
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "petr@example.com" {
+		t.Fatalf("expected one reset mail to petr@example.com, got %+v", sent)
+	}
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(sent[0].Body)
+	if match == nil {
+		t.Fatalf("no reset link in mail body %q", sent[0].Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if internal.HashToken(token) != tokenHash.value {
+		t.Fatal("mailed token does not match the stored hash")
+	}
+
+	// Reset: the token is consumed, the password replaced and every session
+	// revoked, all in one transaction.
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, member_id, expires_at FROM password_reset_token")).
+		WithArgs(sameAs{tokenHash}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "member_id", "expires_at"}).
+			AddRow(1, memberID, time.Now().Add(time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE password_reset_token SET used_at")).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE member SET password = $1")).
+		WithArgs(sqlmock.AnyArg(), memberID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_token SET revoked_at")).
+		WithArgs(memberID, "").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE auth_session SET revoked_at")).
+		WithArgs(memberID, "").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	w = httptest.NewRecorder()
+	ResetPassword(w, httptest.NewRequest(http.MethodPost, "/api/v1/auth/password/reset",
+		strings.NewReader(`{"token":"`+token+`","password":"a-new-password"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset: status %d, body %s", w.Code, w.Body)
+	}
+	cleared := map[string]bool{}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			cleared[cookie.Name] = true
+		}
+	}
+	if !cleared["access_token"] || !cleared["refresh_token"] {
+		t.Errorf("expected both auth cookies to be cleared, got %v", w.Result().Cookies())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResetPasswordRejectsUnknownToken(t *testing.T) {
+	mock := setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, member_id, expires_at FROM password_reset_token")).
+		WithArgs(internal.HashToken("not-a-token")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "member_id", "expires_at"}))
+	mock.ExpectRollback()
+
+	w := httptest.NewRecorder()
+	ResetPassword(w, httptest.NewRequest(http.MethodPost, "/api/v1/auth/password/reset",
+		strings.NewReader(`{"token":"not-a-token","password":"a-new-password"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResetPasswordRollsBackWhenRevocationFails(t *testing.T) {
+	mock := setupMockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, member_id, expires_at FROM password_reset_token")).
+		WithArgs(internal.HashToken("the-token")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "member_id", "expires_at"}).
+			AddRow(1, 42, time.Now().Add(time.Hour)))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE password_reset_token SET used_at")).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE member SET password = $1")).
+		WithArgs(sqlmock.AnyArg(), 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE refresh_token SET revoked_at")).
+		WithArgs(42, "").
+		WillReturnError(driver.ErrBadConn)
+	mock.ExpectRollback()
+
+	w := httptest.NewRecorder()
+	ResetPassword(w, httptest.NewRequest(http.MethodPost, "/api/v1/auth/password/reset",
+		strings.NewReader(`{"token":"the-token","password":"a-new-password"}`)))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
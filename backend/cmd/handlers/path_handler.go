@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+)
+
+const (
+	maxPathDepth     = 6
+	defaultPathLimit = 5
+	maxPathLimit     = 25
+)
+
+type ConnectionPath struct {
+	Target    string          `json:"target"`
+	Connected bool            `json:"connected"`
+	Degree    int             `json:"degree,omitempty"`
+	Path      []CardSummary   `json:"path,omitempty"`
+	Paths     [][]CardSummary `json:"paths,omitempty"`
+}
+
+// GetConnectionPath answers "how do I know this person": the caller's
+// shortest FRIENDS_WITH paths to the target, up to limit of them. Card
+// details of each hop are only included when that card is visible at the
+// hop's distance from the caller.
+func GetConnectionPath(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	target := chi.URLParam(r, "username")
+	limit := queryInt(r, "limit", defaultPathLimit)
+	if limit < 1 || limit > maxPathLimit {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPathLimit))
+		return
+	}
+	if target == username {
+		writeJSONMessage(w, http.StatusBadRequest, "Target is the caller")
+		return
+	}
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	connection, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := transaction.Run(r.Context(),
+			"MATCH (me:User {username: $username}), (target:User {username: $target}) "+
+				"WHERE "+notBlocked("me", "target")+" "+
+				fmt.Sprintf("OPTIONAL MATCH p = allShortestPaths((me)-[:FRIENDS_WITH*..%d]-(target)) ", maxPathDepth)+
+				"WHERE none(x IN nodes(p) WHERE exists { (me)-[:BLOCKED]-(x) }) "+
+				"WITH p, [n IN nodes(p) | n.username] AS usernames ORDER BY usernames LIMIT $limit "+
+				"RETURN [n IN nodes(p) | {username: n.username, card: head([(n)-[:HAS_CARD]->(c:Card) | properties(c)])}] AS hops",
+			map[string]interface{}{"username": username, "target": target, "limit": limit})
+		if err != nil {
+			return nil, err
+		}
+		records, err := result.Collect(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, nil
+		}
+
+		connection := &ConnectionPath{Target: target}
+		for _, record := range records {
+			value, _ := record.Get("hops")
+			hops, _ := value.([]interface{})
+			if len(hops) == 0 {
+				continue
+			}
+			path := make([]CardSummary, 0, len(hops))
+			for distance, hop := range hops {
+				// Every prefix of a shortest path is a shortest path, so a
+				// hop's index is its distance from the caller.
+				props, _ := hop.(map[string]interface{})
+				name, _ := props["username"].(string)
+				card, _ := props["card"].(map[string]interface{})
+				path = append(path, cardSummary(name, card, distance))
+			}
+			connection.Paths = append(connection.Paths, path)
+		}
+		if len(connection.Paths) > 0 {
+			connection.Connected = true
+			connection.Path = connection.Paths[0]
+			connection.Degree = len(connection.Path) - 1
+		}
+		return connection, nil
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to find connection")
+		return
+	}
+	if connection == nil {
+		writeJSONMessage(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(connection)
+}
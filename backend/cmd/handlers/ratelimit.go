@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/petr-discover/config"
+	"github.com/petr-discover/internal"
+)
+
+var (
+	rateLimitConfig *config.RateLimitConfig
+	rateLimitStore  internal.RateLimitStore
+	rateLimitOnce   sync.Once
+)
+
+// SetRateLimitStore replaces the in-process store, e.g. with a shared one
+// when running several instances. Call it before serving requests.
+func SetRateLimitStore(store internal.RateLimitStore) {
+	rateLimitStore = store
+}
+
+func rateLimiter() (*config.RateLimitConfig, internal.RateLimitStore) {
+	rateLimitOnce.Do(func() {
+		rateLimitConfig = config.RateLimiterConfig()
+		if rateLimitStore == nil {
+			rateLimitStore = internal.NewMemoryRateLimitStore()
+		}
+	})
+	return rateLimitConfig, rateLimitStore
+}
+
+// RateLimit applies the token bucket configured for group. Signed-in callers
+// are limited per member, personal access tokens per token and everyone else
+// per IP. It must run after Authenticate. Store errors fail open.
+func RateLimit(group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg, store := rateLimiter()
+			rule, ok := cfg.Groups[group]
+			if !cfg.Enabled || !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limit := internal.RateLimit{Requests: rule.Requests, Period: rule.Period, Burst: rule.Burst}
+			key := group + ":ip:" + clientIP(r)
+			if principal, ok := PrincipalFrom(r.Context()); ok {
+				key = group + ":m:" + strconv.FormatInt(principal.MemberID, 10)
+				if principal.TokenID != 0 {
+					key = group + ":t:" + strconv.FormatInt(principal.TokenID, 10)
+					if burst, ok := cfg.TokenBursts[principal.TokenPrefix]; ok {
+						limit.Burst = burst
+					}
+				}
+			}
+
+			result, err := store.Take(r.Context(), key, limit, time.Now())
+			if err != nil {
+				log.Println("Rate limiter:", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", limit.Requests, int(limit.Period.Seconds()), limit.Burst))
+			if !result.Allowed {
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				writeJSONMessage(w, http.StatusTooManyRequests, "Rate limit exceeded, try again later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
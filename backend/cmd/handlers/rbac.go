@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+
+	PermMembersRead    = "members:read"
+	PermMembersSuspend = "members:suspend"
+	PermRolesWrite     = "roles:write"
+	PermCardsDelete    = "cards:delete"
+	PermCardsHistory   = "cards:history"
+	PermGraphStats     = "graph:stats"
+	PermAuditRead      = "audit:read"
+)
+
+var knownRoles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// rolePermissions grants permissions per role; a principal holds the union
+// of its roles' permissions.
+var rolePermissions = map[string][]string{
+	RoleModerator: {PermMembersRead, PermMembersSuspend, PermCardsDelete, PermCardsHistory},
+	RoleAdmin:     {PermMembersRead, PermMembersSuspend, PermRolesWrite, PermCardsDelete, PermCardsHistory, PermGraphStats, PermAuditRead},
+}
+
+func (p *Principal) Can(permission string) bool {
+	for _, role := range p.Roles {
+		if containsString(rolePermissions[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirePermission admits cookie sessions whose roles grant permission.
+// Roles are read from the access token, so grants and removals apply from
+// the next token refresh.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFrom(r.Context())
+			if !ok {
+				writeJSONMessage(w, http.StatusUnauthorized, "Not logged in")
+				return
+			}
+			if principal.TokenID != 0 || !principal.Can(permission) {
+				writeJSONMessage(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
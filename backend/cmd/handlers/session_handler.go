@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/petr-discover/cmd/database"
+)
+
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+
+	sessions, err := database.DBMain.ListSessions(r.Context(), principal.MemberID)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to retrieve sessions")
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == principal.SessionID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"sessions": sessions})
+}
+
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	sessionID := chi.URLParam(r, "id")
+
+	found, err := database.DBMain.RevokeSession(r.Context(), principal.MemberID, sessionID)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if sessionID == principal.SessionID {
+		clearJWTCookies(w)
+	}
+	writeJSONMessage(w, http.StatusOK, "Session revoked")
+}
+
+func RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+
+	err := database.DBMain.RevokeOtherSessions(r.Context(), principal.MemberID, principal.SessionID)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "Other sessions revoked")
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/cmd/models"
+	"github.com/petr-discover/config"
+)
+
+const (
+	defaultSuggestionLimit = 20
+	maxSuggestionLimit     = 100
+	// maxAttributeCandidates caps the cards each shared attribute
+	// contributes, so a common value such as a large city does not pull in
+	// the whole graph.
+	maxAttributeCandidates = 200
+)
+
+var (
+	suggestionConfig     *config.SuggestionConfig
+	suggestionConfigOnce sync.Once
+)
+
+func friendSuggestionConfig() *config.SuggestionConfig {
+	suggestionConfigOnce.Do(func() {
+		suggestionConfig = config.FriendSuggestionConfig()
+		// Attributes are spliced into the query as property names.
+		attributes := []string{}
+		for _, attribute := range suggestionConfig.Attributes {
+			if field, ok := models.CardFieldByName(attribute); !ok || field.VisibilityOnly {
+				log.Printf("suggestions: ignoring unknown card attribute %q", attribute)
+				continue
+			}
+			attributes = append(attributes, attribute)
+		}
+		suggestionConfig.Attributes = attributes
+	})
+	return suggestionConfig
+}
+
+type FriendSuggestion struct {
+	CardSummary
+	Score            float64  `json:"score"`
+	MutualFriends    int      `json:"mutual_friends"`
+	MutualSample     []string `json:"mutual_sample,omitempty"`
+	SharedAttributes []string `json:"shared_attributes,omitempty"`
+	SharedEvents     int      `json:"shared_events"`
+	Reasons          []string `json:"reasons"`
+}
+
+// suggestionQuery gathers candidates reachable through a friend, a shared
+// event or a card attribute, drops friends, pending requests, dismissed and
+// blocked users, and returns their signals for scoring. Each attribute is
+// one indexed lookup on the caller's value. Events are
+// (:User)-[:ATTENDED]->(:Event).
+func suggestionQuery(attributes []string) string {
+	var query strings.Builder
+	query.WriteString("MATCH (me:User {username: $username}) " +
+		"OPTIONAL MATCH (me)-[:HAS_CARD]->(mine:Card) " +
+		"CALL { " +
+		"WITH me MATCH (me)-[:FRIENDS_WITH]->(:User)-[:FRIENDS_WITH]->(c:User) RETURN c " +
+		"UNION " +
+		"WITH me MATCH (me)-[:ATTENDED]->(:Event)<-[:ATTENDED]-(c:User) RETURN c ")
+	for _, attribute := range attributes {
+		fmt.Fprintf(&query, "UNION "+
+			"WITH mine MATCH (theirs:Card) WHERE theirs.%[1]s = mine.%[1]s "+
+			"WITH theirs LIMIT $attributeCandidates MATCH (c:User)-[:HAS_CARD]->(theirs) RETURN c ", attribute)
+	}
+	query.WriteString("} " +
+		"WITH me, mine, c WHERE c <> me " +
+		"AND NOT (me)-[:FRIENDS_WITH]-(c) " +
+		"AND NOT (me)-[:DISMISSED_SUGGESTION]->(c) " +
+		"AND NOT exists { (me)-[:BLOCKED]-(c) } " +
+		"AND NOT exists { MATCH (me)-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest)-[:TO_USER]->(c) WHERE " + livePendingRequest + " } " +
+		"AND NOT exists { MATCH (c)-[:SENT_FRIEND_REQUEST]->(fr:FriendRequest)-[:TO_USER]->(me) WHERE " + livePendingRequest + " } " +
+		"CALL { WITH me, c MATCH (me)-[:FRIENDS_WITH]->(f:User)-[:FRIENDS_WITH]->(c) WHERE NOT exists { (me)-[:BLOCKED]-(f) } " +
+		"WITH DISTINCT f ORDER BY f.username RETURN count(f) AS mutual, collect(f.username)[..3] AS sample } " +
+		"CALL { WITH me, c MATCH (me)-[:ATTENDED]->(e:Event)<-[:ATTENDED]-(c) RETURN count(DISTINCT e) AS events } " +
+		"OPTIONAL MATCH (c)-[:HAS_CARD]->(theirs:Card) " +
+		"RETURN c.username AS username, properties(theirs) AS card, properties(mine) AS mine, mutual, sample, events")
+	return query.String()
+}
+
+// sharedAttributes lists the attributes on which the candidate's card
+// matches the caller's and which it shows to a viewer at distance.
+func sharedAttributes(attributes []string, mine, theirs map[string]interface{}, distance int) []string {
+	visible := visibleCard(theirs, distance)
+	shared := []string{}
+	for _, attribute := range attributes {
+		if value, ok := visible[attribute]; ok && value != nil && value == mine[attribute] {
+			shared = append(shared, attribute)
+		}
+	}
+	return shared
+}
+
+func GetFriendSuggestions(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	limit := queryInt(r, "limit", defaultSuggestionLimit)
+	if limit < 1 || limit > maxSuggestionLimit {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSuggestionLimit))
+		return
+	}
+	cfg := friendSuggestionConfig()
+
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	suggestions, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := transaction.Run(r.Context(), suggestionQuery(cfg.Attributes), map[string]interface{}{
+			"username":            username,
+			"attributeCandidates": maxAttributeCandidates,
+		})
+		if err != nil {
+			return nil, err
+		}
+		records, err := result.Collect(r.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions := []FriendSuggestion{}
+		for _, record := range records {
+			props := record.AsMap()
+			candidate, _ := props["username"].(string)
+			card, _ := props["card"].(map[string]interface{})
+			mine, _ := props["mine"].(map[string]interface{})
+			mutual, _ := props["mutual"].(int64)
+			events, _ := props["events"].(int64)
+			distance := strangerDistance
+			if mutual > 0 {
+				distance = 2
+			}
+			suggestion := FriendSuggestion{
+				CardSummary:      cardSummary(candidate, card, distance),
+				MutualFriends:    int(mutual),
+				MutualSample:     stringList(props["sample"]),
+				SharedAttributes: sharedAttributes(cfg.Attributes, mine, card, distance),
+				SharedEvents:     int(events),
+			}
+			suggestion.Score = cfg.MutualWeight*float64(suggestion.MutualFriends) +
+				cfg.AttributeWeight*float64(len(suggestion.SharedAttributes)) +
+				cfg.EventWeight*float64(suggestion.SharedEvents)
+			if suggestion.Score <= 0 {
+				continue
+			}
+			suggestion.Reasons = suggestionReasons(suggestion)
+			suggestions = append(suggestions, suggestion)
+		}
+		sort.Slice(suggestions, func(i, j int) bool {
+			if suggestions[i].Score != suggestions[j].Score {
+				return suggestions[i].Score > suggestions[j].Score
+			}
+			return suggestions[i].Username < suggestions[j].Username
+		})
+		if len(suggestions) > limit {
+			suggestions = suggestions[:limit]
+		}
+		return suggestions, nil
+	})
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to load suggestions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"suggestions": suggestions})
+}
+
+// DismissSuggestion hides a user from the caller's suggestions until the
+// dismissal is undone with RestoreSuggestion.
+func DismissSuggestion(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	target := chi.URLParam(r, "username")
+	if target == username {
+		writeJSONMessage(w, http.StatusBadRequest, "You cannot dismiss yourself")
+		return
+	}
+
+	found, err := writeSuggestionDismissal(r, username, target,
+		"MATCH (me:User {username: $username}), (c:User {username: $target}) "+
+			"MERGE (me)-[d:DISMISSED_SUGGESTION]->(c) ON CREATE SET d.dismissed_at = datetime() "+
+			"RETURN count(d) AS matched")
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to dismiss suggestion")
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "User not found")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "Suggestion dismissed")
+}
+
+func RestoreSuggestion(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	target := chi.URLParam(r, "username")
+
+	found, err := writeSuggestionDismissal(r, username, target,
+		"MATCH (:User {username: $username})-[d:DISMISSED_SUGGESTION]->(:User {username: $target}) "+
+			"DELETE d RETURN count(d) AS matched")
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to restore suggestion")
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "Suggestion was not dismissed")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "Suggestion restored")
+}
+
+func writeSuggestionDismissal(r *http.Request, username, target, query string) (bool, error) {
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	defer session.Close(r.Context())
+
+	matched, err := session.ExecuteWrite(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := transaction.Run(r.Context(), query,
+			map[string]interface{}{"username": username, "target": target})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		matched, _ := record.Get("matched")
+		return matched, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	count, _ := matched.(int64)
+	return count > 0, nil
+}
+
+func suggestionReasons(suggestion FriendSuggestion) []string {
+	reasons := []string{}
+	switch suggestion.MutualFriends {
+	case 0:
+	case 1:
+		reasons = append(reasons, "1 mutual friend")
+	default:
+		reasons = append(reasons, fmt.Sprintf("%d mutual friends", suggestion.MutualFriends))
+	}
+	if len(suggestion.SharedAttributes) > 0 {
+		reasons = append(reasons, "Same "+strings.Join(suggestion.SharedAttributes, ", "))
+	}
+	switch suggestion.SharedEvents {
+	case 0:
+	case 1:
+		reasons = append(reasons, "1 shared event")
+	default:
+		reasons = append(reasons, fmt.Sprintf("%d shared events", suggestion.SharedEvents))
+	}
+	return reasons
+}
+
+func stringList(value interface{}) []string {
+	items, _ := value.([]interface{})
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
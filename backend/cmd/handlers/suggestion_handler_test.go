@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSharedAttributesRespectVisibility(t *testing.T) {
+	attributes := []string{"school", "major", "city"}
+	mine := map[string]interface{}{"school": "UCI", "major": "CS", "city": "Irvine"}
+
+	tests := []struct {
+		name     string
+		theirs   map[string]interface{}
+		distance int
+		want     []string
+	}{
+		{"friend of a friend sees defaults", map[string]interface{}{"school": "UCI", "major": "CS", "city": "Irvine"}, 2, []string{"school", "major", "city"}},
+		{"stranger sees none by default", map[string]interface{}{"school": "UCI", "major": "CS", "city": "Irvine"}, strangerDistance, []string{}},
+		{"different values", map[string]interface{}{"school": "UCLA", "major": "CS"}, 2, []string{"major"}},
+		{"field level overrides", map[string]interface{}{"school": "UCI", "city": "Irvine", "visibility_school": "public", "visibility_city": "friends"}, strangerDistance, []string{"school"}},
+		{"card-wide level", map[string]interface{}{"school": "UCI", "major": "CS", "visibility": "private"}, 2, []string{}},
+		{"no card", nil, 2, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sharedAttributes(attributes, mine, tt.theirs, tt.distance); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sharedAttributes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := sharedAttributes(attributes, nil, map[string]interface{}{"school": "UCI"}, 2); len(got) != 0 {
+		t.Errorf("expected nothing shared without a card of the caller's, got %v", got)
+	}
+}
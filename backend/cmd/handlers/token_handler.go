@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/internal"
+)
+
+const (
+	ScopeGraphRead    = "graph:read"
+	ScopeCardRead     = "card:read"
+	ScopeCardWrite    = "card:write"
+	ScopeFriendsRead  = "friends:read"
+	ScopeFriendsWrite = "friends:write"
+
+	accessTokenPrefix        = "pdt_"
+	accessTokenPrefixLength  = len(accessTokenPrefix) + 8
+	defaultAccessTokenDays   = 30
+	maxAccessTokenDays       = 365
+	maxAccessTokenNameLength = 100
+)
+
+var knownScopes = []string{ScopeGraphRead, ScopeCardRead, ScopeCardWrite, ScopeFriendsRead, ScopeFriendsWrite}
+
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreateAccessToken returns the token once; only its hash and the visible
+// prefix are kept.
+func CreateAccessToken(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+
+	var tokenRequest CreateAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&tokenRequest); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	tokenRequest.Name = strings.TrimSpace(tokenRequest.Name)
+	if tokenRequest.Name == "" || len(tokenRequest.Name) > maxAccessTokenNameLength {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("Name must be 1-%d characters", maxAccessTokenNameLength))
+		return
+	}
+	scopes, err := normalizeScopes(tokenRequest.Scopes)
+	if err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if tokenRequest.ExpiresInDays == 0 {
+		tokenRequest.ExpiresInDays = defaultAccessTokenDays
+	}
+	if tokenRequest.ExpiresInDays < 0 || tokenRequest.ExpiresInDays > maxAccessTokenDays {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("expires_in_days must be between 1 and %d", maxAccessTokenDays))
+		return
+	}
+
+	secret, _, err := internal.NewOpaqueToken()
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+	raw := accessTokenPrefix + secret
+	ttl := time.Duration(tokenRequest.ExpiresInDays) * 24 * time.Hour
+	token, err := database.DBMain.CreateAccessToken(r.Context(), principal.MemberID, tokenRequest.Name,
+		raw[:accessTokenPrefixLength], internal.HashToken(raw), scopes, ttl)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"token": raw, "access_token": token})
+}
+
+func ListAccessTokens(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	tokens, err := database.DBMain.ListAccessTokens(r.Context(), principal.MemberID)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to list tokens")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"tokens": tokens})
+}
+
+func RevokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	principal := MustPrincipal(r.Context())
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSONMessage(w, http.StatusNotFound, "Token not found")
+		return
+	}
+
+	found, err := database.DBMain.RevokeAccessToken(r.Context(), principal.MemberID, tokenID)
+	if err != nil {
+		log.Println(err)
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to revoke token")
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "Token not found")
+		return
+	}
+	writeJSONMessage(w, http.StatusOK, "Token revoked")
+}
+
+func normalizeScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, fmt.Errorf("At least one scope is required: %s", strings.Join(knownScopes, ", "))
+	}
+	for _, scope := range requested {
+		if !containsString(knownScopes, scope) {
+			return nil, fmt.Errorf("Unknown scope %q", scope)
+		}
+	}
+	var scopes []string
+	for _, known := range knownScopes {
+		if containsString(requested, known) {
+			scopes = append(scopes, known)
+		}
+	}
+	return scopes, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBearerToken resolves an Authorization: Bearer personal access token.
+func checkBearerToken(r *http.Request, token string) (*Principal, error) {
+	if !strings.HasPrefix(token, accessTokenPrefix) {
+		return nil, fmt.Errorf("unsupported bearer token")
+	}
+	owner, err := database.DBMain.LookupAccessToken(r.Context(), internal.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if owner == nil {
+		return nil, fmt.Errorf("personal access token is invalid, expired or revoked")
+	}
+	return &Principal{
+		Username:    owner.Username,
+		MemberID:    owner.MemberID,
+		Roles:       owner.Roles,
+		TokenID:     owner.TokenID,
+		TokenPrefix: owner.Prefix,
+		Scopes:      owner.Scopes,
+	}, nil
+}
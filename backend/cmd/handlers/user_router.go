@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,20 +10,23 @@ import (
 	"mime/multipart"
 	"net/http"
 	"path/filepath"
+	"strings"
 
 	"cloud.google.com/go/storage"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
-	"github.com/neo4j/neo4j-go-driver/v5/neo4j/dbtype"
 	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/cmd/models"
 	"google.golang.org/api/option"
 )
 
 const (
 	bucketName = "petr-bucket"
+	bucketURL  = "https://storage.googleapis.com/" + bucketName + "/"
 )
 
 type FriendRequest struct {
 	UserName string `json:"username"`
+	Message  string `json:"message"`
 }
 
 type UserCardRequest struct {
@@ -31,19 +35,8 @@ type UserCardRequest struct {
 	UserProfileImage string `json:"file"`
 }
 
-func UserCtx(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		next.ServeHTTP(w, r)
-	})
-}
-
 func CreateUserCard(w http.ResponseWriter, r *http.Request) {
-	username, exists := CheckLogin(w, r)
-	if !exists {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Not logged in"))
-		return
-	}
+	username := MustPrincipal(r.Context()).Username
 	var userCard UserCardRequest
 	err := r.ParseMultipartForm(10 << 20) // 10 MB limit
 	if err != nil {
@@ -62,31 +55,27 @@ func CreateUserCard(w http.ResponseWriter, r *http.Request) {
 	firstName := r.Form.Get("first_name")
 	lastName := r.Form.Get("last_name")
 
-	url, err := uploadHandler(file, handler)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to upload image"}`))
+	// Reject the form before uploading where possible; later failures
+	// delete the uploaded image again.
+	card := models.Card{FirstName: firstName, LastName: lastName, Version: 1}
+	if errs := card.Validate(); len(errs) > 0 {
+		writeCardErrors(w, errs)
 		return
 	}
 
-	userCard = UserCardRequest{
-		FirstName:        firstName,
-		LastName:         lastName,
-		UserProfileImage: url,
-	}
-
 	session := database.Neo4jDriver.NewSession(database.Neo4jCtx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
 	defer session.Close(database.Neo4jCtx)
 
 	result, err := session.Run(database.Neo4jCtx,
-		"MATCH (u:User {username: $username}) RETURN u",
+		"MATCH (u:User {username: $username})-[:HAS_CARD]->(c:Card) RETURN c",
 		map[string]interface{}{
 			"username": username,
 		})
-
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"message":"Failed to create User and Card nodes"}`))
+		return
 	}
 	if result.Next(database.Neo4jCtx) {
 		w.Header().Set("Content-Type", "application/json")
@@ -95,238 +84,230 @@ func CreateUserCard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = session.ExecuteWrite(database.Neo4jCtx, func(transaction neo4j.ManagedTransaction) (any, error) {
-		_, err := transaction.Run(database.Neo4jCtx,
-			"CREATE (u:User {username: $username})-[:HAS_CARD]->(c:Card {first_name: $first_name, last_name: $last_name, user_profile_image: $user_profile_image}) RETURN u, c",
-			map[string]any{
-				"username":           username,
-				"first_name":         userCard.FirstName,        // Replace with actual first_name from request/body
-				"last_name":          userCard.LastName,         // Replace with actual last_name from request/body
-				"user_profile_image": userCard.UserProfileImage, // Replace with actual URL from request/body
-			})
-		if err != nil {
-			return nil, err
-		}
-
-		return nil, nil
-	})
+	url, err := uploadHandler(file, handler)
 	if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to create User and Card nodes"}`))
+		w.Write([]byte(`{"message":"Failed to upload image"}`))
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"message":"User and Card nodes created successfully"}`))
-}
-
-func AddFriend(w http.ResponseWriter, r *http.Request) {
-	username, exists := CheckLogin(w, r)
-	if !exists {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Not logged in"))
+	card.UserProfileImage = url
+	if errs := card.Validate(); len(errs) > 0 {
+		discardUpload(url)
+		writeCardErrors(w, errs)
 		return
 	}
 
-	var friendRequest FriendRequest
-	err := json.NewDecoder(r.Body).Decode(&friendRequest)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+	userCard = UserCardRequest{
+		FirstName:        firstName,
+		LastName:         lastName,
+		UserProfileImage: url,
 	}
 
-	session := database.Neo4jDriver.NewSession(database.Neo4jCtx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
-	defer session.Close(database.Neo4jCtx)
-
-	_, err = session.ExecuteWrite(database.Neo4jCtx, func(transaction neo4j.ManagedTransaction) (interface{}, error) {
-		// Check if there is a pending friend request
-		result, err := transaction.Run(database.Neo4jCtx, "MATCH (u:User {username: $friend_username})-[friendRequest:SENT_FRIEND_REQUEST]-(n:FriendRequest{status: 'pending'})-[dd:TO_USER]->(f:User {username: $username}) RETURN n.status",
-			map[string]interface{}{
-				"username":        username,
-				"friend_username": friendRequest.UserName,
+	created, err := session.ExecuteWrite(database.Neo4jCtx, func(transaction neo4j.ManagedTransaction) (any, error) {
+		// The write lock on u serializes concurrent creates, so only one of
+		// them sees the user without a card.
+		result, err := transaction.Run(database.Neo4jCtx,
+			"MERGE (u:User {username: $username}) "+
+				"SET u._lock = true REMOVE u._lock "+
+				"WITH u WHERE NOT (u)-[:HAS_CARD]->(:Card) "+
+				"CREATE (u)-[:HAS_CARD]->(c:Card {first_name: $first_name, last_name: $last_name, user_profile_image: $user_profile_image, version: 1}) "+
+				"RETURN c",
+			map[string]any{
+				"username":           username,
+				"first_name":         userCard.FirstName,        // Replace with actual first_name from request/body
+				"last_name":          userCard.LastName,         // Replace with actual last_name from request/body
+				"user_profile_image": userCard.UserProfileImage, // Replace with actual URL from request/body
 			})
 		if err != nil {
-			return nil, err
+			return false, err
 		}
-
-		if result.Next(database.Neo4jCtx) {
-			_, err := transaction.Run(database.Neo4jCtx,
-				"MATCH (u:User)-[s:SENT_FRIEND_REQUEST]-(fr:FriendRequest)-[dd:TO_USER]->(uu:User) WHERE u.username = $friend_username AND uu.username = $username DELETE fr, dd, s",
-				map[string]interface{}{
-					"username":        username,
-					"friend_username": friendRequest.UserName,
-				})
-			if err != nil {
-				return nil, err
-			}
-
-			_, err = transaction.Run(database.Neo4jCtx,
-				"MATCH (u:User {username: $username}), (f:User {username: $friend_username}) "+
-					"MERGE (u)-[:FRIENDS_WITH]->(f) "+
-					"MERGE (f)-[:FRIENDS_WITH]->(u)",
-				map[string]interface{}{
-					"username":        username,
-					"friend_username": friendRequest.UserName,
-				})
-			if err != nil {
-				return nil, err
-			}
-
-		} else {
-			_, err := transaction.Run(database.Neo4jCtx,
-				"MATCH (u:User {username: $username}), (f:User {username: $friend_username}) "+
-					"MERGE (u)-[:SENT_FRIEND_REQUEST]->(request:FriendRequest {status: 'pending', sender: $sender})-[:TO_USER]->(f) RETURN request",
-				map[string]interface{}{
-					"username":        username,
-					"friend_username": friendRequest.UserName,
-					"sender":          username, // Add the sender property here
-				})
-			if err != nil {
-				return nil, err
-			}
+		records, err := result.Collect(database.Neo4jCtx)
+		if err != nil || len(records) == 0 {
+			return false, err
 		}
 
-		return nil, nil
+		return true, recordCardRevision(database.Neo4jCtx, transaction, username, username, card, models.Card{}.Diff(card), "")
 	})
-
 	if err != nil {
 		log.Println(err)
+		discardUpload(url)
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to add friend"}`))
+		w.Write([]byte(`{"message":"Failed to create User and Card nodes"}`))
+		return
+	}
+	if !created.(bool) {
+		discardUpload(url)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message":"User Card Already Exists"}`))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"message":"Friend request processed successfully"}`))
+	w.Write([]byte(`{"message":"User and Card nodes created successfully"}`))
 }
 
+// GetUser returns a user's card as the caller sees it: only the fields whose
+// visibility admits the caller's distance to the owner. Owners also get the
+// effective visibility of each field.
 func GetUser(w http.ResponseWriter, r *http.Request) {
-	var username string
-	n, exists := CheckLogin(w, r)
-	if !exists {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Not logged in"))
-		return
-	}
-	var requestBody map[string]interface{}
-	err := json.NewDecoder(r.Body).Decode(&requestBody)
-	if err != nil {
-		log.Println(err)
-		return
+	viewer := MustPrincipal(r.Context()).Username
+	username := r.URL.Query().Get("username")
+	if username == "" && r.ContentLength != 0 {
+		var requestBody map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+			writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		username, _ = requestBody["username"].(string)
 	}
-	usernameFromBody, exists := requestBody["username"].(string)
-	if !exists || usernameFromBody == "" {
-		username = n
-	} else {
-		username = usernameFromBody
+	if username == "" {
+		username = viewer
 	}
 
-	session := database.Neo4jDriver.NewSession(database.Neo4jCtx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
-	defer session.Close(database.Neo4jCtx)
-
-	result, err := session.Run(database.Neo4jCtx,
-		"MATCH (u:User {username: $username})-[:HAS_CARD]->(c:Card) RETURN u, c",
-		map[string]interface{}{
-			"username": username,
-		})
+	view, err := loadCardView(r, viewer, username)
 	if err != nil {
 		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to retrieve user data"}`))
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to retrieve user data")
 		return
 	}
-
-	record, err := result.Single(database.Neo4jCtx)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"User not found"}`))
+	if view == nil {
+		writeJSONMessage(w, http.StatusNotFound, "User not found")
 		return
 	}
 
-	userNode, ok := record.Values[0].(dbtype.Node)
-	if !ok {
-		log.Println("Failed to convert to Node")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to convert to Node"}`))
-		return
+	response := map[string]interface{}{
+		"user": map[string]interface{}{"username": username},
+		"card": visibleCard(view.Card, view.Distance),
 	}
-
-	cardNode, ok := record.Values[1].(dbtype.Node)
-	if !ok {
-		log.Println("Failed to convert to Node")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to convert to Node"}`))
-		return
+	if view.Distance == 0 {
+		card := models.CardFromProps(view.Card)
+		response["visibility"] = cardVisibilities(view.Card)
+		response["version"] = card.Version
+		w.Header().Set("ETag", cardETag(card.Version))
 	}
-
-	userProps := userNode.Props
-	cardProps := cardNode.Props
-
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	response := map[string]interface{}{
-		"user": userProps,
-		"card": cardProps,
-	}
 	json.NewEncoder(w).Encode(response)
 }
 
-func UpdateUser(w http.ResponseWriter, r *http.Request) {
-	username, exists := CheckLogin(w, r)
-	if !exists {
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write([]byte("Not logged in"))
+// PreviewCard shows the caller's card the way an audience sees it, either a
+// visibility level (?audience=friends) or a specific user (?as=alice).
+func PreviewCard(w http.ResponseWriter, r *http.Request) {
+	username := MustPrincipal(r.Context()).Username
+	query := r.URL.Query()
+	audience, as := query.Get("audience"), query.Get("as")
+	if (audience == "") == (as == "") {
+		writeJSONMessage(w, http.StatusBadRequest, "Give exactly one of audience or as")
 		return
 	}
 
-	// Parse JSON request body
-	var updateRequest map[string]interface{}
-	err := json.NewDecoder(r.Body).Decode(&updateRequest)
+	view, err := loadCardView(r, username, username)
 	if err != nil {
 		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"message":"Invalid request body"}`))
+		writeJSONMessage(w, http.StatusInternalServerError, "Failed to retrieve card")
+		return
+	}
+	if view == nil {
+		writeJSONMessage(w, http.StatusNotFound, "You have no card")
 		return
 	}
 
-	cardPropsToUpdate, ok := updateRequest["card"].(map[string]interface{})
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"message":"Invalid card properties in request"}`))
+	distance := strangerDistance
+	switch audience {
+	case "":
+		asView, err := loadCardView(r, as, username)
+		if err != nil {
+			log.Println(err)
+			writeJSONMessage(w, http.StatusInternalServerError, "Failed to retrieve card")
+			return
+		}
+		// Unknown and blocked viewers are previewed as strangers, so the
+		// response does not reveal which of the two they are.
+		if asView != nil {
+			distance = asView.Distance
+		}
+	case models.VisibilityPublic:
+	case models.VisibilityFriendsOfFriends:
+		distance = 2
+	case models.VisibilityFriends:
+		distance = 1
+	case models.VisibilityPrivate:
+		distance = 0
+	default:
+		writeJSONMessage(w, http.StatusBadRequest, "audience must be one of "+strings.Join(models.VisibilityLevels, ", "))
 		return
 	}
 
-	session := database.Neo4jDriver.NewSession(database.Neo4jCtx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
-	defer session.Close(database.Neo4jCtx)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"card": visibleCard(view.Card, distance)})
+}
 
-	_, err = session.ExecuteWrite(database.Neo4jCtx, func(transaction neo4j.ManagedTransaction) (any, error) {
-		_, err := transaction.Run(database.Neo4jCtx,
-			"MATCH (u:User {username: $username})-[:HAS_CARD]->(c:Card) SET c += $cardPropsToUpdate RETURN u, c",
-			map[string]any{
-				"username":          username,
-				"cardPropsToUpdate": cardPropsToUpdate,
-			})
+type cardView struct {
+	Card     map[string]interface{}
+	Distance int
+}
+
+// loadCardView loads owner's card and viewer's distance to it. It returns
+// nil when either user or the card is missing, or one blocked the other.
+func loadCardView(r *http.Request, viewer, owner string) (*cardView, error) {
+	session := database.Neo4jDriver.NewSession(r.Context(), neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+	defer session.Close(r.Context())
+
+	view, err := session.ExecuteRead(r.Context(), func(transaction neo4j.ManagedTransaction) (interface{}, error) {
+		result, err := transaction.Run(r.Context(),
+			"MATCH (viewer:User {username: $viewer}), (f:User {username: $owner})-[:HAS_CARD]->(c:Card) "+
+				"WHERE "+notBlocked("viewer", "f")+" "+
+				"RETURN properties(c) AS card, "+distanceCypher("viewer", "f")+" AS distance",
+			map[string]interface{}{"viewer": viewer, "owner": owner})
 		if err != nil {
 			return nil, err
 		}
-
-		return nil, nil
+		records, err := result.Collect(r.Context())
+		if err != nil || len(records) == 0 {
+			return nil, err
+		}
+		props := records[0].AsMap()
+		card, _ := props["card"].(map[string]interface{})
+		distance, _ := props["distance"].(int64)
+		return &cardView{Card: card, Distance: int(distance)}, nil
 	})
+	if err != nil || view == nil {
+		return nil, err
+	}
+	return view.(*cardView), nil
+}
 
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(`{"message":"Failed to update card properties"}`))
+// UpdateUser is the original card update, kept for existing clients:
+//
+//	{"card": {"school": "UCI"}, "visibility": {"school": "friends"}}
+//
+// It is applied as a merge patch, with the same validation as PatchUser.
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	var updateRequest struct {
+		Card       map[string]interface{} `json:"card"`
+		Visibility map[string]interface{} `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&updateRequest); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(updateRequest.Card) == 0 && len(updateRequest.Visibility) == 0 {
+		writeJSONMessage(w, http.StatusBadRequest, "Nothing to update")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"message":"Card properties updated successfully"}`))
+	patch := map[string]interface{}{}
+	for name, value := range updateRequest.Card {
+		patch[name] = value
+	}
+	if len(updateRequest.Visibility) > 0 {
+		patch["visibility"] = updateRequest.Visibility
+	}
+	applyCardPatch(w, r, patch, "")
 }
 
 func uploadHandler(file multipart.File, handler *multipart.FileHeader) (string, error) {
@@ -338,19 +319,19 @@ func uploadHandler(file multipart.File, handler *multipart.FileHeader) (string,
 
 	// Initialize Google Cloud Storage client
 	ctx := context.Background()
-
-	currentDir, _ := filepath.Abs(filepath.Dir("."))
-	log.Printf("Current Working Directory: %s\n", currentDir)
-	keyPath := filepath.Join(currentDir, "auth.json")
-	log.Printf("Key Path: %s\n", keyPath)
-	client, err := storage.NewClient(ctx, option.WithCredentialsFile(keyPath))
+	client, err := newStorageClient(ctx)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 		return "", err
 	}
 
-	// Generate a unique object name or use the original file name
-	objectName := fmt.Sprintf("%d_%s", handler.Size, handler.Filename)
+	// Generate a unique object name, so discarding an upload never removes
+	// an image another card points at.
+	unique := make([]byte, 8)
+	if _, err := rand.Read(unique); err != nil {
+		return "", err
+	}
+	objectName := fmt.Sprintf("%x_%d_%s", unique, handler.Size, handler.Filename)
 
 	// Upload file to Google Cloud Storage
 	if err := uploadFile(ctx, client, bucketName, fileBytes, objectName); err != nil {
@@ -359,11 +340,34 @@ func uploadHandler(file multipart.File, handler *multipart.FileHeader) (string,
 	}
 
 	// Generate URL for the uploaded file
-	url := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucketName, objectName)
+	url := bucketURL + objectName
 
 	return url, nil
 }
 
+// discardUpload deletes an image uploaded for a card that was then not
+// created. Failures are only logged; the image is merely orphaned.
+func discardUpload(url string) {
+	ctx := context.Background()
+	client, err := newStorageClient(ctx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer client.Close()
+	if err := client.Bucket(bucketName).Object(strings.TrimPrefix(url, bucketURL)).Delete(ctx); err != nil {
+		log.Println(err)
+	}
+}
+
+func newStorageClient(ctx context.Context) (*storage.Client, error) {
+	currentDir, _ := filepath.Abs(filepath.Dir("."))
+	log.Printf("Current Working Directory: %s\n", currentDir)
+	keyPath := filepath.Join(currentDir, "auth.json")
+	log.Printf("Key Path: %s\n", keyPath)
+	return storage.NewClient(ctx, option.WithCredentialsFile(keyPath))
+}
+
 func uploadFile(ctx context.Context, client *storage.Client, bucketName string, fileBytes []byte, objectName string) error {
 	bucket := client.Bucket(bucketName)
 	wc := bucket.Object(objectName).NewWriter(ctx)
@@ -0,0 +1,255 @@
+package models
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Card visibility levels, from the widest audience to the narrowest. A
+// field is shown to viewers at most MaxDistance FRIENDS_WITH hops from the
+// owner.
+const (
+	VisibilityPublic           = "public"
+	VisibilityFriendsOfFriends = "friends_of_friends"
+	VisibilityFriends          = "friends"
+	VisibilityPrivate          = "private"
+)
+
+var VisibilityLevels = []string{VisibilityPublic, VisibilityFriendsOfFriends, VisibilityFriends, VisibilityPrivate}
+
+// CardField describes one field of a card. Visibility-only fields, such as
+// friend_list, hold no value on the card and only control who sees
+// something derived from it.
+type CardField struct {
+	Name              string
+	DefaultVisibility string
+	VisibilityOnly    bool
+}
+
+const FriendListField = "friend_list"
+
+var CardFields = []CardField{
+	{Name: "first_name", DefaultVisibility: VisibilityPublic},
+	{Name: "last_name", DefaultVisibility: VisibilityPublic},
+	{Name: "user_profile_image", DefaultVisibility: VisibilityPublic},
+	{Name: "bio", DefaultVisibility: VisibilityPublic},
+	{Name: "school", DefaultVisibility: VisibilityFriendsOfFriends},
+	{Name: "major", DefaultVisibility: VisibilityFriendsOfFriends},
+	{Name: "company", DefaultVisibility: VisibilityFriendsOfFriends},
+	{Name: "city", DefaultVisibility: VisibilityFriendsOfFriends},
+	{Name: "email", DefaultVisibility: VisibilityFriends},
+	{Name: "phone", DefaultVisibility: VisibilityFriends},
+	{Name: FriendListField, DefaultVisibility: VisibilityFriendsOfFriends, VisibilityOnly: true},
+}
+
+func CardFieldByName(name string) (CardField, bool) {
+	for _, field := range CardFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return CardField{}, false
+}
+
+// VisibilityProperty is the Card node property holding a field's level.
+func VisibilityProperty(field string) string {
+	return "visibility_" + field
+}
+
+// MaxDistance is the furthest viewer a level admits; -1 for unknown levels.
+func MaxDistance(level string) int {
+	switch level {
+	case VisibilityPublic:
+		return 1 << 30
+	case VisibilityFriendsOfFriends:
+		return 2
+	case VisibilityFriends:
+		return 1
+	case VisibilityPrivate:
+		return 0
+	}
+	return -1
+}
+
+// ProfileImageHosts are the hosts user_profile_image may point at; uploads
+// go to Cloud Storage.
+var ProfileImageHosts = []string{"storage.googleapis.com"}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ()-]{7,20}$`)
+
+// Card is a user's card as stored on the Card node. Version counts updates
+// and backs If-Match; it is never set by clients.
+type Card struct {
+	FirstName        string            `json:"first_name,omitempty"`
+	LastName         string            `json:"last_name,omitempty"`
+	UserProfileImage string            `json:"user_profile_image,omitempty"`
+	Bio              string            `json:"bio,omitempty"`
+	School           string            `json:"school,omitempty"`
+	Major            string            `json:"major,omitempty"`
+	Company          string            `json:"company,omitempty"`
+	City             string            `json:"city,omitempty"`
+	Email            string            `json:"email,omitempty"`
+	Phone            string            `json:"phone,omitempty"`
+	Visibility       map[string]string `json:"visibility,omitempty"`
+	Version          int64             `json:"-"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// values maps schema field names to the Card's fields.
+func (c *Card) values() map[string]*string {
+	return map[string]*string{
+		"first_name":         &c.FirstName,
+		"last_name":          &c.LastName,
+		"user_profile_image": &c.UserProfileImage,
+		"bio":                &c.Bio,
+		"school":             &c.School,
+		"major":              &c.Major,
+		"company":            &c.Company,
+		"city":               &c.City,
+		"email":              &c.Email,
+		"phone":              &c.Phone,
+	}
+}
+
+// CardFromProps reads a Card node's properties, ignoring anything outside
+// the schema.
+func CardFromProps(props map[string]interface{}) Card {
+	card := Card{Visibility: map[string]string{}}
+	for name, value := range card.values() {
+		*value, _ = props[name].(string)
+	}
+	for _, field := range CardFields {
+		if level, ok := props[VisibilityProperty(field.Name)].(string); ok {
+			card.Visibility[field.Name] = level
+		}
+	}
+	card.Version, _ = props["version"].(int64)
+	return card
+}
+
+// Props is the property map for SET c += props. Empty fields and unset
+// levels are null so that SET removes them.
+func (c Card) Props() map[string]interface{} {
+	props := map[string]interface{}{}
+	for name, value := range c.values() {
+		props[name] = nil
+		if *value != "" {
+			props[name] = *value
+		}
+	}
+	for _, field := range CardFields {
+		props[VisibilityProperty(field.Name)] = nil
+		if level, ok := c.Visibility[field.Name]; ok {
+			props[VisibilityProperty(field.Name)] = level
+		}
+	}
+	return props
+}
+
+func (c Card) Validate() []FieldError {
+	errs := []FieldError{}
+	check := func(field string, ok bool, message string) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: message})
+		}
+	}
+	maxLength := func(field, value string, n int) {
+		check(field, utf8.RuneCountInString(value) <= n, fmt.Sprintf("must be at most %d characters", n))
+	}
+
+	check("first_name", strings.TrimSpace(c.FirstName) != "", "is required")
+	maxLength("first_name", c.FirstName, 50)
+	maxLength("last_name", c.LastName, 50)
+	maxLength("bio", c.Bio, 500)
+	for _, field := range []string{"school", "major", "company", "city"} {
+		maxLength(field, *c.values()[field], 100)
+	}
+	if c.UserProfileImage != "" {
+		u, err := url.Parse(c.UserProfileImage)
+		check("user_profile_image", err == nil && u.Scheme == "https" && containsHost(ProfileImageHosts, u.Host),
+			"must be an https URL on "+strings.Join(ProfileImageHosts, ", "))
+	}
+	if c.Email != "" {
+		address, err := mail.ParseAddress(c.Email)
+		check("email", err == nil && address.Address == c.Email && len(c.Email) <= 254, "must be an email address")
+	}
+	if c.Phone != "" {
+		check("phone", phonePattern.MatchString(c.Phone), "must be a phone number")
+	}
+	for name, level := range c.Visibility {
+		_, known := CardFieldByName(name)
+		check("visibility."+name, known, "unknown field")
+		check("visibility."+name, MaxDistance(level) >= 0, "must be one of "+strings.Join(VisibilityLevels, ", "))
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+func containsHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// CardChange is one field that differs between two cards. Visibility levels
+// appear as "visibility.<field>"; empty values mean unset.
+type CardChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// Diff lists the changes from c to next in schema order.
+func (c Card) Diff(next Card) []CardChange {
+	changes := []CardChange{}
+	from, to := c.values(), next.values()
+	for _, field := range CardFields {
+		if !field.VisibilityOnly && *from[field.Name] != *to[field.Name] {
+			changes = append(changes, CardChange{Field: field.Name, From: *from[field.Name], To: *to[field.Name]})
+		}
+	}
+	for _, field := range CardFields {
+		if c.Visibility[field.Name] != next.Visibility[field.Name] {
+			changes = append(changes, CardChange{
+				Field: "visibility." + field.Name,
+				From:  c.Visibility[field.Name],
+				To:    next.Visibility[field.Name],
+			})
+		}
+	}
+	return changes
+}
+
+// Patch is the merge patch that turns any card into c.
+func (c Card) Patch() map[string]interface{} {
+	patch := map[string]interface{}{}
+	levels := map[string]interface{}{}
+	values := c.values()
+	for _, field := range CardFields {
+		levels[field.Name] = nil
+		if level, ok := c.Visibility[field.Name]; ok {
+			levels[field.Name] = level
+		}
+		if field.VisibilityOnly {
+			continue
+		}
+		patch[field.Name] = nil
+		if *values[field.Name] != "" {
+			patch[field.Name] = *values[field.Name]
+		}
+	}
+	patch["visibility"] = levels
+	return patch
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCardValidate(t *testing.T) {
+	valid := func(modify func(c *Card)) Card {
+		c := Card{FirstName: "Petr"}
+		modify(&c)
+		return c
+	}
+	tests := []struct {
+		name   string
+		card   Card
+		fields []string
+	}{
+		{"minimal", valid(func(c *Card) {}), nil},
+		{"full", valid(func(c *Card) {
+			c.LastName = "Anteater"
+			c.UserProfileImage = "https://storage.googleapis.com/petr-bucket/petr.png"
+			c.Bio = "Hi"
+			c.Email = "petr@example.com"
+			c.Phone = "+1 (949) 824-5011"
+			c.Visibility = map[string]string{"email": VisibilityPrivate, FriendListField: VisibilityFriends}
+		}), nil},
+		{"missing first name", valid(func(c *Card) { c.FirstName = "" }), []string{"first_name"}},
+		{"blank first name", valid(func(c *Card) { c.FirstName = "   " }), []string{"first_name"}},
+		{"first name at limit", valid(func(c *Card) { c.FirstName = strings.Repeat("é", 50) }), nil},
+		{"first name too long", valid(func(c *Card) { c.FirstName = strings.Repeat("a", 51) }), []string{"first_name"}},
+		{"last name too long", valid(func(c *Card) { c.LastName = strings.Repeat("a", 51) }), []string{"last_name"}},
+		{"bio too long", valid(func(c *Card) { c.Bio = strings.Repeat("a", 501) }), []string{"bio"}},
+		{"school too long", valid(func(c *Card) { c.School = strings.Repeat("a", 101) }), []string{"school"}},
+		{"major too long", valid(func(c *Card) { c.Major = strings.Repeat("a", 101) }), []string{"major"}},
+		{"company too long", valid(func(c *Card) { c.Company = strings.Repeat("a", 101) }), []string{"company"}},
+		{"city too long", valid(func(c *Card) { c.City = strings.Repeat("a", 101) }), []string{"city"}},
+		{"image over http", valid(func(c *Card) { c.UserProfileImage = "http://storage.googleapis.com/petr-bucket/a.png" }), []string{"user_profile_image"}},
+		{"image on another host", valid(func(c *Card) { c.UserProfileImage = "https://evil.example/a.png" }), []string{"user_profile_image"}},
+		{"image not a URL", valid(func(c *Card) { c.UserProfileImage = "https://storage.googleapis.com/%zz" }), []string{"user_profile_image"}},
+		{"email with display name", valid(func(c *Card) { c.Email = "Petr <petr@example.com>" }), []string{"email"}},
+		{"email without domain", valid(func(c *Card) { c.Email = "petr" }), []string{"email"}},
+		{"email too long", valid(func(c *Card) { c.Email = strings.Repeat("a", 250) + "@example.com" }), []string{"email"}},
+		{"phone with letters", valid(func(c *Card) { c.Phone = "949-CALL-PETR" }), []string{"phone"}},
+		{"phone too short", valid(func(c *Card) { c.Phone = "12345" }), []string{"phone"}},
+		{"unknown visibility field", valid(func(c *Card) { c.Visibility = map[string]string{"age": VisibilityPublic} }), []string{"visibility.age"}},
+		{"unknown visibility level", valid(func(c *Card) { c.Visibility = map[string]string{"email": "everyone"} }), []string{"visibility.email"}},
+		{"errors sorted by field", valid(func(c *Card) {
+			c.FirstName = ""
+			c.Phone = "x"
+			c.Email = "x"
+		}), []string{"email", "first_name", "phone"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := []string{}
+			for _, err := range tt.card.Validate() {
+				fields = append(fields, err.Field)
+			}
+			if tt.fields == nil {
+				tt.fields = []string{}
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("errors on %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
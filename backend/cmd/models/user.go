@@ -4,6 +4,11 @@ import (
 	"time"
 )
 
+const (
+	MemberActive    = "active"
+	MemberSuspended = "suspended"
+)
+
 type Member struct {
 	ID        int64     `db:"id" dataType:"SERIAL PRIMARY KEY" constraint:"NOT NULL"`
 	Username  string    `db:"username" dataType:"VARCHAR(50)" constraint:"NOT NULL UNIQUE"`
@@ -11,4 +16,11 @@ type Member struct {
 	Email     string    `db:"email" dataType:"VARCHAR(50)" constraint:"NOT NULL UNIQUE"`
 	CreatedAt time.Time `db:"created_at" dataType:"TIMESTAMP" constraint:"NOT NULL DEFAULT CURRENT_TIMESTAMP"`
 	UpdatedAt time.Time `db:"updated_at" dataType:"TIMESTAMP" constraint:"NOT NULL DEFAULT CURRENT_TIMESTAMP"`
+
+	EmailVerifiedAt *time.Time `db:"email_verified_at" dataType:"TIMESTAMP"`
+
+	// Roles is a space-separated list, e.g. "user admin".
+	Roles       string     `db:"roles" dataType:"VARCHAR(255)" constraint:"NOT NULL DEFAULT 'user'"`
+	Status      string     `db:"status" dataType:"VARCHAR(20)" constraint:"NOT NULL DEFAULT 'active'"`
+	SuspendedAt *time.Time `db:"suspended_at" dataType:"TIMESTAMP"`
 }
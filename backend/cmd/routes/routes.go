@@ -13,23 +13,28 @@ func NewRouter(port string) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(handlers.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(cors.Handler(cors.Options{
 		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
 		AllowedOrigins: []string{"https://*", "http://*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		// AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		// ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match"},
+		ExposedHeaders:   []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After", "ETag"},
 		AllowCredentials: true,
 		// MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 
+	r.Use(handlers.Authenticate)
+
+	r.Get("/.well-known/jwks.json", handlers.JWKS)
+
 	authRouter(r)
 	userRouter(r)
 	friendRouter(r)
+	adminRouter(r)
 
 	log.Println("Server is running on port ", port)
 
@@ -38,30 +43,107 @@ func NewRouter(port string) *chi.Mux {
 
 func authRouter(r *chi.Mux) {
 	r.Route("/api/v1/auth", func(r chi.Router) {
-		r.Use(handlers.AuthCtx)
+		r.Use(handlers.RateLimit("auth"))
 		r.Post("/register", handlers.CreateUser)
 		r.Post("/login", handlers.Login)
 		r.Post("/logout", handlers.Logout)
-		r.Get("/google/login", handlers.GoogleAuth)
-		r.Get("/google/callback", handlers.GoogleCallback)
+		r.With(handlers.RequireAuth).Post("/logout/all", handlers.LogoutAll)
+		r.Post("/password/forgot", handlers.ForgotPassword)
+		r.Post("/password/reset", handlers.ResetPassword)
+		r.Get("/email/verify", handlers.VerifyEmail)
+		r.Post("/email/verify", handlers.VerifyEmail)
+		r.With(handlers.RequireAuth).Post("/email/resend", handlers.ResendVerification)
+		r.Route("/mfa", func(r chi.Router) {
+			r.Post("/verify", handlers.VerifyMFA)
+			r.Group(func(r chi.Router) {
+				r.Use(handlers.RequireAuth)
+				r.Post("/enroll", handlers.EnrollMFA)
+				r.Post("/confirm", handlers.ConfirmMFA)
+				r.Post("/recovery-codes", handlers.RegenerateRecoveryCodes)
+				r.Delete("/", handlers.DisableMFA)
+			})
+		})
+		r.Route("/sessions", func(r chi.Router) {
+			r.Use(handlers.RequireAuth)
+			r.Get("/", handlers.ListSessions)
+			r.Delete("/", handlers.RevokeOtherSessions)
+			r.Delete("/{id}", handlers.RevokeSession)
+		})
+		r.Route("/tokens", func(r chi.Router) {
+			r.Use(handlers.RequireAuth)
+			r.Get("/", handlers.ListAccessTokens)
+			r.Post("/", handlers.CreateAccessToken)
+			r.Delete("/{id}", handlers.RevokeAccessToken)
+		})
+		r.Route("/identities", func(r chi.Router) {
+			r.Use(handlers.RequireAuth)
+			r.Get("/", handlers.ListIdentities)
+			r.Delete("/{provider}", handlers.UnlinkIdentity)
+		})
+		r.Get("/{provider}/login", handlers.OIDCLogin)
+		r.With(handlers.RequireAuth).Get("/{provider}/link", handlers.OIDCLink)
+		r.Get("/{provider}/callback", handlers.OIDCCallback)
+		r.Post("/{provider}/signup", handlers.CompleteOIDCSignup)
 	})
 }
 
 func userRouter(r *chi.Mux) {
 	r.Route("/api/v1/user", func(r chi.Router) {
-		r.Use(handlers.UserCtx)
-		r.Post("/", handlers.CreateUserCard)
-		r.Get("/", handlers.GetUser)
-		r.Put("/", handlers.UpdateUser)
-		r.Post("/friend", handlers.AddFriend)
+		r.Use(handlers.RateLimit("user"))
+		r.With(handlers.RequireScope(handlers.ScopeCardWrite), handlers.RateLimit("upload")).Post("/", handlers.CreateUserCard)
+		r.With(handlers.RequireScope(handlers.ScopeCardRead)).Get("/", handlers.GetUser)
+		r.With(handlers.RequireScope(handlers.ScopeCardRead)).Get("/preview", handlers.PreviewCard)
+		r.With(handlers.RequireScope(handlers.ScopeCardWrite)).Put("/", handlers.UpdateUser)
+		r.With(handlers.RequireScope(handlers.ScopeCardWrite)).Patch("/", handlers.PatchUser)
+		r.With(handlers.RequireScope(handlers.ScopeCardRead)).Get("/revisions", handlers.ListCardRevisions)
+		r.With(handlers.RequireScope(handlers.ScopeCardRead)).Get("/revisions/{revision}", handlers.GetCardRevision)
+		r.With(handlers.RequireScope(handlers.ScopeCardWrite)).Post("/revisions/{revision}/restore", handlers.RestoreCardRevision)
+		r.With(handlers.RequireScope(handlers.ScopeFriendsWrite), handlers.RequireVerifiedEmail).Post("/friend", handlers.AddFriend)
 	})
 }
 
 func friendRouter(r *chi.Mux) {
 	r.Route("/api/v1/friends", func(r chi.Router) {
-		r.Use(handlers.FriendCtx)
-		r.Get("/pending", handlers.GetPendingFriend)
-		r.Delete("/", handlers.DeleteFriend)
-		r.Get("/", handlers.GetGraph)
+		r.Use(handlers.RateLimit("friends"))
+		r.With(handlers.RequireScope(handlers.ScopeFriendsRead)).Get("/pending", handlers.GetPendingFriend)
+		r.Route("/blocks", func(r chi.Router) {
+			r.With(handlers.RequireScope(handlers.ScopeFriendsRead)).Get("/", handlers.ListBlockedUsers)
+			r.With(handlers.RequireScope(handlers.ScopeFriendsWrite)).Post("/{username}", handlers.BlockUser)
+			r.With(handlers.RequireScope(handlers.ScopeFriendsWrite)).Delete("/{username}", handlers.UnblockUser)
+		})
+		r.Route("/requests", func(r chi.Router) {
+			r.With(handlers.RequireScope(handlers.ScopeFriendsRead)).Get("/incoming", handlers.GetPendingFriend)
+			r.With(handlers.RequireScope(handlers.ScopeFriendsRead)).Get("/sent", handlers.GetSentFriendRequests)
+			r.With(handlers.RequireScope(handlers.ScopeFriendsWrite), handlers.RequireVerifiedEmail).Post("/{username}/accept", handlers.AcceptFriendRequest)
+			r.With(handlers.RequireScope(handlers.ScopeFriendsWrite)).Post("/{username}/decline", handlers.DeclineFriendRequest)
+			r.With(handlers.RequireScope(handlers.ScopeFriendsWrite)).Delete("/{username}", handlers.CancelFriendRequest)
+		})
+		r.With(handlers.RequireScope(handlers.ScopeGraphRead)).Get("/path/{username}", handlers.GetConnectionPath)
+		r.With(handlers.RequireScope(handlers.ScopeFriendsRead)).Get("/list", handlers.ListFriends)
+		r.With(handlers.RequireScope(handlers.ScopeFriendsRead)).Get("/list/{username}", handlers.ListFriends)
+		r.With(handlers.RequireScope(handlers.ScopeFriendsRead)).Get("/mutual/{username}", handlers.ListMutualFriends)
+		r.With(handlers.RequireScope(handlers.ScopeFriendsRead)).Get("/suggestions", handlers.GetFriendSuggestions)
+		r.With(handlers.RequireScope(handlers.ScopeFriendsWrite)).Post("/suggestions/{username}/dismiss", handlers.DismissSuggestion)
+		r.With(handlers.RequireScope(handlers.ScopeFriendsWrite)).Delete("/suggestions/{username}/dismiss", handlers.RestoreSuggestion)
+		r.With(handlers.RequireScope(handlers.ScopeFriendsWrite)).Delete("/", handlers.DeleteFriend)
+		r.With(handlers.RequireScope(handlers.ScopeGraphRead)).Get("/", handlers.GetGraph)
+	})
+}
+
+func adminRouter(r *chi.Mux) {
+	r.Route("/api/v1/admin", func(r chi.Router) {
+		r.Use(handlers.RateLimit("admin"))
+		r.With(handlers.RequirePermission(handlers.PermMembersRead)).Get("/members", handlers.AdminListMembers)
+		r.With(handlers.RequirePermission(handlers.PermMembersRead)).Get("/members/{id}", handlers.AdminGetMember)
+		r.With(handlers.RequirePermission(handlers.PermMembersSuspend)).Post("/members/{id}/suspend", handlers.AdminSuspendMember)
+		r.With(handlers.RequirePermission(handlers.PermMembersSuspend)).Post("/members/{id}/reactivate", handlers.AdminReactivateMember)
+		r.With(handlers.RequirePermission(handlers.PermRolesWrite)).Put("/members/{id}/roles", handlers.AdminSetRoles)
+		r.With(handlers.RequirePermission(handlers.PermCardsDelete)).Delete("/members/{id}/card", handlers.AdminDeleteCard)
+		r.With(handlers.RequirePermission(handlers.PermCardsHistory)).Get("/members/{id}/card/revisions", handlers.AdminListCardRevisions)
+		r.With(handlers.RequirePermission(handlers.PermCardsHistory)).Get("/members/{id}/card/revisions/{revision}", handlers.AdminGetCardRevision)
+		r.With(handlers.RequirePermission(handlers.PermGraphStats)).Get("/graph/stats", handlers.AdminGraphStats)
+		r.With(handlers.RequirePermission(handlers.PermAuditRead)).Get("/audit", handlers.AdminListAudit)
+		r.With(handlers.RequirePermission(handlers.PermMembersRead)).Get("/lockouts", handlers.AdminListLockouts)
+		r.With(handlers.RequirePermission(handlers.PermMembersSuspend)).Delete("/lockouts/{key}", handlers.AdminClearLockout)
 	})
 }
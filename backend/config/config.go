@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
-
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var Neo4jUser string
@@ -19,27 +22,80 @@ type AppConfig struct {
 	DBType string
 }
 
-type JWTConfig struct {
-	SecretKey  string
-	RefreshKey string
+type SyncConfig struct {
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
 }
 
-var GoogleURLAPI string
+type FriendRequestConfig struct {
+	TTL             time.Duration
+	DeclineCooldown time.Duration
+	SweepInterval   time.Duration
+}
+
+type OIDCProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+}
 
-var GoogleOauthConfig oauth2.Config
+type MailConfig struct {
+	Backend  string
+	From     string
+	SMTPHost string
+	SMTPPort int
+	SMTPUser string
+	SMTPPass string
+	LogFile  string
+}
 
-var GoogleStorageKey = getEnv("GOOGLE_STORAGE_KEY", "secret")
+type LoginLimitConfig struct {
+	Backend          string
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	FailureWindow    time.Duration
+	IPMultiplier     int
+}
 
-func GoogleAuthConfig() {
-	loadEnv()
-	GoogleOauthConfig = oauth2.Config{
-		RedirectURL:  getEnv("REDIRECT_URL", "http://localhost:8080/api/v1/auth/google/callback"),
-		ClientID:     getEnv("CLIENT_ID", "some_id"),
-		ClientSecret: getEnv("CLIENT_SECRET", "some_secret"),
-		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email"},
-		Endpoint:     google.Endpoint,
-	}
-	GoogleURLAPI = getEnv("GOOGLE_URL_API", "https://www.googleapis.com/oauth2/v2/userinfo?access_token=")
+type RateLimitRule struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+type RateLimitConfig struct {
+	Enabled bool
+	Groups  map[string]RateLimitRule
+	// TokenBursts maps a personal access token prefix to the burst it may
+	// use instead of its group's.
+	TokenBursts map[string]int
+}
+
+// SuggestionConfig weighs the signals behind friend suggestions. A
+// candidate scores MutualWeight per mutual friend, AttributeWeight per
+// shared card attribute and EventWeight per shared event.
+type SuggestionConfig struct {
+	MutualWeight    float64
+	AttributeWeight float64
+	EventWeight     float64
+	Attributes      []string
+}
+
+type JWTConfig struct {
+	SecretKey      string
+	RefreshKey     string
+	Issuer         string
+	Algorithm      string
+	SigningKeyFile string
+	SigningKeyID   string
+	VerifyKeyFiles map[string]string
 }
 
 func Neo4jDBConfig() string {
@@ -76,6 +132,236 @@ func JWTSecretKey() *JWTConfig {
 	cfg := &JWTConfig{
 		SecretKey:  getEnv("JWT_SECRET_KEY", "secret"),
 		RefreshKey: getEnv("JWT_REFReSH_KEY", "refresh"),
+		Issuer:     getEnv("JWT_ISSUER", "petr-discover"),
+		// HS256 is the legacy mode; RS256 and EdDSA read a PEM private key
+		// and publish its public half at /.well-known/jwks.json.
+		Algorithm:      getEnv("JWT_ALG", "HS256"),
+		SigningKeyFile: getEnv("JWT_SIGNING_KEY_FILE", ""),
+		SigningKeyID:   getEnv("JWT_SIGNING_KEY_ID", ""),
+		VerifyKeyFiles: getEnvMap("JWT_VERIFY_KEY_FILES"),
+	}
+	return cfg
+}
+
+func MemberSyncConfig() *SyncConfig {
+	loadEnv()
+	cfg := &SyncConfig{
+		Interval:    time.Duration(getEnvInt("SYNC_INTERVAL_MS", 2000)) * time.Millisecond,
+		BatchSize:   getEnvInt("SYNC_BATCH_SIZE", 50),
+		MaxAttempts: getEnvInt("SYNC_MAX_ATTEMPTS", 10),
+	}
+	return cfg
+}
+
+func FriendRequestsConfig() *FriendRequestConfig {
+	loadEnv()
+	cfg := &FriendRequestConfig{
+		TTL:             time.Duration(getEnvInt("FRIEND_REQUEST_TTL_DAYS", 30)) * 24 * time.Hour,
+		DeclineCooldown: time.Duration(getEnvInt("FRIEND_REQUEST_DECLINE_COOLDOWN_DAYS", 7)) * 24 * time.Hour,
+		SweepInterval:   time.Duration(getEnvInt("FRIEND_REQUEST_SWEEP_MINUTES", 60)) * time.Minute,
+	}
+	return cfg
+}
+
+func MailerConfig() *MailConfig {
+	loadEnv()
+	cfg := &MailConfig{
+		Backend:  getEnv("MAIL_BACKEND", "log"),
+		From:     getEnv("MAIL_FROM", "no-reply@petr-discover.local"),
+		SMTPHost: getEnv("SMTP_HOST", "localhost"),
+		SMTPPort: getEnvInt("SMTP_PORT", 587),
+		SMTPUser: getEnv("SMTP_USER", ""),
+		SMTPPass: getEnv("SMTP_PASS", ""),
+		LogFile:  getEnv("MAIL_LOG_FILE", ""),
+	}
+	return cfg
+}
+
+func LoginLimiterConfig() *LoginLimitConfig {
+	loadEnv()
+	cfg := &LoginLimitConfig{
+		Backend:          getEnv("LOGIN_LIMIT_BACKEND", "memory"),
+		FreeAttempts:     getEnvInt("LOGIN_FREE_ATTEMPTS", 3),
+		BaseDelay:        time.Duration(getEnvInt("LOGIN_BASE_DELAY_SECONDS", 1)) * time.Second,
+		MaxDelay:         time.Duration(getEnvInt("LOGIN_MAX_DELAY_SECONDS", 60)) * time.Second,
+		LockoutThreshold: getEnvInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LockoutDuration:  time.Duration(getEnvInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		FailureWindow:    time.Duration(getEnvInt("LOGIN_FAILURE_WINDOW_MINUTES", 60)) * time.Minute,
+		IPMultiplier:     getEnvInt("LOGIN_IP_MULTIPLIER", 5),
+	}
+	return cfg
+}
+
+func FriendSuggestionConfig() *SuggestionConfig {
+	loadEnv()
+	cfg := &SuggestionConfig{
+		MutualWeight:    getEnvFloat("SUGGEST_MUTUAL_WEIGHT", 1),
+		AttributeWeight: getEnvFloat("SUGGEST_ATTRIBUTE_WEIGHT", 0.5),
+		EventWeight:     getEnvFloat("SUGGEST_EVENT_WEIGHT", 0.75),
+	}
+	for _, attribute := range strings.Split(getEnv("SUGGEST_CARD_ATTRIBUTES", "school,major,company,city"), ",") {
+		if attribute = strings.TrimSpace(attribute); attribute != "" {
+			cfg.Attributes = append(cfg.Attributes, attribute)
+		}
+	}
+	return cfg
+}
+
+// TrustedProxies lists the addresses or CIDR ranges, from TRUSTED_PROXIES,
+// whose X-Forwarded-For and X-Real-IP headers are believed.
+func TrustedProxies() []string {
+	loadEnv()
+	var proxies []string
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+var defaultRateLimits = map[string]string{
+	"auth":    "20/m:20",
+	"user":    "60/m:30",
+	"upload":  "10/m:5",
+	"friends": "120/m:60",
+	"admin":   "120/m:60",
+}
+
+// RateLimiterConfig reads RATE_LIMIT_<GROUP> rules written as
+// "requests/period[:burst]" with period s, m or h, e.g. "60/m:30".
+func RateLimiterConfig() *RateLimitConfig {
+	loadEnv()
+	cfg := &RateLimitConfig{
+		Enabled:     getEnv("RATE_LIMIT_ENABLED", "true") != "false",
+		Groups:      map[string]RateLimitRule{},
+		TokenBursts: map[string]int{},
+	}
+	for group, fallback := range defaultRateLimits {
+		value := getEnv("RATE_LIMIT_"+strings.ToUpper(group), fallback)
+		rule, err := parseRateLimitRule(value)
+		if err != nil {
+			log.Printf("invalid RATE_LIMIT_%s %q: %s", strings.ToUpper(group), value, err.Error())
+			rule, _ = parseRateLimitRule(fallback)
+		}
+		cfg.Groups[group] = rule
+	}
+	for prefix, burst := range getEnvMap("RATE_LIMIT_TOKEN_BURSTS") {
+		n, err := strconv.Atoi(burst)
+		if err != nil || n < 1 {
+			log.Printf("invalid RATE_LIMIT_TOKEN_BURSTS entry %s=%s", prefix, burst)
+			continue
+		}
+		cfg.TokenBursts[prefix] = n
 	}
 	return cfg
 }
+
+func parseRateLimitRule(value string) (RateLimitRule, error) {
+	spec, burst, hasBurst := strings.Cut(value, ":")
+	count, unit, ok := strings.Cut(spec, "/")
+	if !ok {
+		return RateLimitRule{}, fmt.Errorf("missing /period")
+	}
+	requests, err := strconv.Atoi(count)
+	if err != nil || requests < 1 {
+		return RateLimitRule{}, fmt.Errorf("invalid request count")
+	}
+	rule := RateLimitRule{Requests: requests, Burst: requests}
+	switch unit {
+	case "s":
+		rule.Period = time.Second
+	case "m":
+		rule.Period = time.Minute
+	case "h":
+		rule.Period = time.Hour
+	default:
+		return RateLimitRule{}, fmt.Errorf("period must be s, m or h")
+	}
+	if hasBurst {
+		rule.Burst, err = strconv.Atoi(burst)
+		if err != nil || rule.Burst < 1 {
+			return RateLimitRule{}, fmt.Errorf("invalid burst")
+		}
+	}
+	return rule, nil
+}
+
+func AppBaseURL() string {
+	loadEnv()
+	return getEnv("APP_BASE_URL", "http://localhost:8080")
+}
+
+func PasswordResetTTL() time.Duration {
+	loadEnv()
+	return time.Duration(getEnvInt("PASSWORD_RESET_TTL_MINUTES", 60)) * time.Minute
+}
+
+func EmailVerificationTTL() time.Duration {
+	loadEnv()
+	return time.Duration(getEnvInt("EMAIL_VERIFICATION_TTL_HOURS", 48)) * time.Hour
+}
+
+// EmailVerificationResendInterval is the minimum time between two
+// verification emails for the same member.
+func EmailVerificationResendInterval() time.Duration {
+	loadEnv()
+	return time.Duration(getEnvInt("EMAIL_VERIFICATION_RESEND_SECONDS", 60)) * time.Second
+}
+
+// OIDCProviders reads the provider registry from the JSON file named by
+// OIDC_PROVIDERS_FILE, or else from OIDC_PROVIDERS=name,name plus
+// OIDC_<NAME>_ISSUER/_CLIENT_ID/_CLIENT_SECRET/_REDIRECT_URL/_SCOPES. With
+// neither set, Google is configured from the legacy CLIENT_ID variables.
+func OIDCProviders() ([]OIDCProviderConfig, error) {
+	loadEnv()
+	var providers []OIDCProviderConfig
+
+	if path := getEnv("OIDC_PROVIDERS_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &providers); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+	} else if names := getEnv("OIDC_PROVIDERS", ""); names != "" {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+			providers = append(providers, OIDCProviderConfig{
+				Name:         name,
+				Issuer:       getEnv(prefix+"ISSUER", ""),
+				ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+				ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+				RedirectURL:  getEnv(prefix+"REDIRECT_URL", ""),
+				Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "")),
+			})
+		}
+	} else {
+		providers = append(providers, OIDCProviderConfig{
+			Name:         "google",
+			Issuer:       "https://accounts.google.com",
+			ClientID:     getEnv("CLIENT_ID", "some_id"),
+			ClientSecret: getEnv("CLIENT_SECRET", "some_secret"),
+			RedirectURL:  getEnv("REDIRECT_URL", "http://localhost:8080/api/v1/auth/google/callback"),
+		})
+	}
+
+	for i := range providers {
+		p := &providers[i]
+		if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %q needs a name, issuer and client_id", p.Name)
+		}
+		if p.RedirectURL == "" {
+			p.RedirectURL = getEnv("APP_BASE_URL", "http://localhost:8080") + "/api/v1/auth/" + p.Name + "/callback"
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+	}
+	return providers, nil
+}
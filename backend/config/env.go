@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -34,3 +35,31 @@ func getEnvInt(key string, defaultVal int) int {
 	}
 	return intValue
 }
+
+func getEnvFloat(key string, defaultVal float64) float64 {
+	value, found := os.LookupEnv(key)
+	if !found {
+		return defaultVal
+	}
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultVal
+	}
+	return floatValue
+}
+
+// getEnvMap parses "key=value,key=value" pairs.
+func getEnvMap(key string) map[string]string {
+	result := map[string]string{}
+	value, found := os.LookupEnv(key)
+	if !found {
+		return result
+	}
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && k != "" {
+			result[k] = v
+		}
+	}
+	return result
+}
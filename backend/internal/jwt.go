@@ -7,25 +7,27 @@ import (
 )
 
 type Claims struct {
-	Authorized bool   `json:"authorized"`
-	User       string `json:"user"`
+	Authorized bool     `json:"authorized"`
+	User       string   `json:"user"`
+	MemberID   int64    `json:"uid,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+	SessionID  string   `json:"sid,omitempty"`
+	Purpose    string   `json:"purpose,omitempty"`
+	// Provider, Email and EmailVerified carry an external identity through
+	// the signup step that picks a username.
+	Provider      string `json:"idp,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
 	jwt.StandardClaims
 }
 
-func GenerateJWT(username string, key string, expiration time.Duration) (string, error) {
-	secretKey := []byte(key)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		Authorized: true,
-		User:       username,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
-		},
-	})
-
-	tokenString, err := token.SignedString(secretKey)
-	if err != nil {
-		return "", err
-	}
+// GenerateJWT signs claims with an HS256 secret. Access tokens go through
+// AccessKeySet instead; this is kept for server-only tokens such as refresh
+// tokens.
+func GenerateJWT(claims Claims, key string, expiration time.Duration) (string, error) {
+	return NewHMACKeySet(key, "").Sign(claims, expiration)
+}
 
-	return tokenString, nil
+func ParseJWT(tokenString string, key string) (*Claims, error) {
+	return NewHMACKeySet(key, "").Parse(tokenString)
 }
@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/petr-discover/config"
+)
+
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+}
+
+// KeySet signs with one active key and verifies against every key it holds,
+// selected by the token's kid header. Keeping the previous public keys in the
+// set lets tokens signed before a rotation verify until they expire.
+type KeySet struct {
+	Issuer  string
+	Active  *SigningKey
+	Keys    map[string]*SigningKey
+	private bool
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	accessKeys     *KeySet
+	accessKeysOnce sync.Once
+	accessKeysErr  error
+)
+
+// AccessKeySet returns the key set for access tokens, loading it from
+// config.JWTSecretKey on first use.
+func AccessKeySet() (*KeySet, error) {
+	accessKeysOnce.Do(func() {
+		accessKeys, accessKeysErr = LoadKeySet(config.JWTSecretKey())
+	})
+	return accessKeys, accessKeysErr
+}
+
+func NewHMACKeySet(secret string, issuer string) *KeySet {
+	key := &SigningKey{Method: jwt.SigningMethodHS256, Private: []byte(secret), Public: []byte(secret)}
+	return &KeySet{Issuer: issuer, Active: key, Keys: map[string]*SigningKey{"": key}, private: true}
+}
+
+func LoadKeySet(cfg *config.JWTConfig) (*KeySet, error) {
+	switch strings.ToUpper(cfg.Algorithm) {
+	case "", "HS256":
+		return NewHMACKeySet(cfg.SecretKey, cfg.Issuer), nil
+	case "RS256", "EDDSA":
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALG %s", cfg.Algorithm)
+	}
+
+	pem, err := os.ReadFile(cfg.SigningKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %s", err.Error())
+	}
+	active, err := parsePrivateKey(cfg.Algorithm, pem)
+	if err != nil {
+		return nil, err
+	}
+	active.ID = cfg.SigningKeyID
+	if active.ID == "" {
+		active.ID, err = keyThumbprint(active.Public)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ks := &KeySet{Issuer: cfg.Issuer, Active: active, Keys: map[string]*SigningKey{active.ID: active}}
+	for kid, path := range cfg.VerifyKeyFiles {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read verification key %s: %s", kid, err.Error())
+		}
+		key, err := parsePublicKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verification key %s: %s", kid, err.Error())
+		}
+		key.ID = kid
+		ks.Keys[kid] = key
+	}
+	return ks, nil
+}
+
+func (ks *KeySet) Sign(claims Claims, expiration time.Duration) (string, error) {
+	claims.Authorized = true
+	claims.Issuer = ks.Issuer
+	claims.IssuedAt = time.Now().Unix()
+	claims.ExpiresAt = time.Now().Add(expiration).Unix()
+
+	token := jwt.NewWithClaims(ks.Active.Method, claims)
+	if ks.Active.ID != "" {
+		token.Header["kid"] = ks.Active.ID
+	}
+	return token.SignedString(ks.Active.Private)
+}
+
+func (ks *KeySet) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.Keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.Public, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.User == "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if ks.Issuer != "" && claims.Issuer != "" && claims.Issuer != ks.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %s", claims.Issuer)
+	}
+	return claims, nil
+}
+
+// JWKS lists the public verification keys. Symmetric keys are never
+// published, so a legacy HS256 set yields an empty document.
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	if ks.private {
+		return jwks
+	}
+	for kid, key := range ks.Keys {
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return jwks
+}
+
+func parsePrivateKey(algorithm string, pem []byte) (*SigningKey, error) {
+	if strings.ToUpper(algorithm) == "RS256" {
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{Method: jwt.SigningMethodRS256, Private: private, Public: &private.PublicKey}, nil
+	}
+	private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, err
+	}
+	edPrivate, ok := private.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is not an Ed25519 key")
+	}
+	return &SigningKey{Method: jwt.SigningMethodEdDSA, Private: edPrivate, Public: edPrivate.Public()}, nil
+}
+
+func parsePublicKey(pem []byte) (*SigningKey, error) {
+	if public, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+		return &SigningKey{Method: jwt.SigningMethodRS256, Public: public}, nil
+	}
+	public, err := jwt.ParseEdPublicKeyFromPEM(pem)
+	if err != nil {
+		return nil, fmt.Errorf("expected an RSA or Ed25519 public key")
+	}
+	return &SigningKey{Method: jwt.SigningMethodEdDSA, Public: public}, nil
+}
+
+func keyThumbprint(public interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
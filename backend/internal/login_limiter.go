@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petr-discover/config"
+)
+
+// AttemptState is the failed-login history of one key, such as
+// "account:alice" or "ip:10.0.0.1".
+type AttemptState struct {
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	LastFailure  time.Time `json:"last_failure"`
+	BlockedUntil time.Time `json:"blocked_until"`
+	Locked       bool      `json:"locked"`
+}
+
+// AttemptStore persists AttemptStates. Update must apply fn atomically with
+// respect to other updates of the same key; a missing key starts from the
+// zero state.
+type AttemptStore interface {
+	Get(ctx context.Context, key string) (AttemptState, error)
+	Update(ctx context.Context, key string, fn func(*AttemptState)) (AttemptState, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, since time.Time) ([]AttemptState, error)
+}
+
+// LoginPolicy allows FreeAttempts failures, then makes each further attempt
+// wait BaseDelay doubling up to MaxDelay, and locks the key for
+// LockoutDuration once LockoutThreshold is reached. Failures older than
+// FailureWindow are forgotten.
+type LoginPolicy struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	FailureWindow    time.Duration
+}
+
+func (p LoginPolicy) recordFailure(state *AttemptState, now time.Time) {
+	if state.Locked && now.After(state.BlockedUntil) || now.Sub(state.LastFailure) > p.FailureWindow {
+		state.Failures = 0
+		state.Locked = false
+	}
+	state.Failures++
+	state.LastFailure = now
+
+	switch {
+	case p.LockoutThreshold > 0 && state.Failures >= p.LockoutThreshold:
+		state.Locked = true
+		state.BlockedUntil = now.Add(p.LockoutDuration)
+	case state.Failures > p.FreeAttempts:
+		delay := p.BaseDelay << uint(state.Failures-p.FreeAttempts-1)
+		if delay > p.MaxDelay || delay <= 0 {
+			delay = p.MaxDelay
+		}
+		state.BlockedUntil = now.Add(delay)
+	}
+}
+
+type LoginLimiter struct {
+	Store   AttemptStore
+	Account LoginPolicy
+	IP      LoginPolicy
+}
+
+// NewLoginLimiter builds the account policy from cfg and an IP policy whose
+// thresholds are cfg.IPMultiplier times higher, since many people can share
+// one address.
+func NewLoginLimiter(store AttemptStore, cfg *config.LoginLimitConfig) *LoginLimiter {
+	account := LoginPolicy{
+		FreeAttempts:     cfg.FreeAttempts,
+		BaseDelay:        cfg.BaseDelay,
+		MaxDelay:         cfg.MaxDelay,
+		LockoutThreshold: cfg.LockoutThreshold,
+		LockoutDuration:  cfg.LockoutDuration,
+		FailureWindow:    cfg.FailureWindow,
+	}
+	ip := account
+	if cfg.IPMultiplier > 1 {
+		ip.FreeAttempts *= cfg.IPMultiplier
+		ip.LockoutThreshold *= cfg.IPMultiplier
+	}
+	return &LoginLimiter{Store: store, Account: account, IP: ip}
+}
+
+func AccountLimitKey(identifier string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+func IPLimitKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Allow returns how long the caller has to wait before trying account from
+// ip; zero means the attempt may go ahead.
+func (l *LoginLimiter) Allow(ctx context.Context, account, ip string, now time.Time) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range []string{AccountLimitKey(account), IPLimitKey(ip)} {
+		state, err := l.Store.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if d := state.BlockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// Failure records a failed attempt and returns the states that this failure
+// locked out.
+func (l *LoginLimiter) Failure(ctx context.Context, account, ip string, now time.Time) ([]AttemptState, error) {
+	var locked []AttemptState
+	keys := []struct {
+		key    string
+		policy LoginPolicy
+	}{{AccountLimitKey(account), l.Account}, {IPLimitKey(ip), l.IP}}
+	for _, k := range keys {
+		var wasLocked bool
+		state, err := l.Store.Update(ctx, k.key, func(state *AttemptState) {
+			wasLocked = state.Locked && now.Before(state.BlockedUntil)
+			k.policy.recordFailure(state, now)
+		})
+		if err != nil {
+			return locked, err
+		}
+		if state.Locked && !wasLocked {
+			locked = append(locked, state)
+		}
+	}
+	return locked, nil
+}
+
+// Success forgets the account's failures. The IP history is kept so one good
+// password does not reset a spraying attack from the same address.
+func (l *LoginLimiter) Success(ctx context.Context, account string) error {
+	return l.Store.Delete(ctx, AccountLimitKey(account))
+}
+
+// Active lists keys with failures inside the account failure window or a
+// block that has not expired yet.
+func (l *LoginLimiter) Active(ctx context.Context, now time.Time) ([]AttemptState, error) {
+	return l.Store.List(ctx, now.Add(-l.IP.FailureWindow))
+}
+
+type MemoryAttemptStore struct {
+	mu      sync.Mutex
+	entries map[string]*AttemptState
+	updates int
+	maxAge  time.Duration
+}
+
+// NewMemoryAttemptStore keeps state in process; entries idle for longer
+// than maxAge are swept periodically.
+func NewMemoryAttemptStore(maxAge time.Duration) *MemoryAttemptStore {
+	return &MemoryAttemptStore{entries: map[string]*AttemptState{}, maxAge: maxAge}
+}
+
+func (s *MemoryAttemptStore) Get(ctx context.Context, key string) (AttemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.entries[key]; ok {
+		return *state, nil
+	}
+	return AttemptState{Key: key}, nil
+}
+
+func (s *MemoryAttemptStore) Update(ctx context.Context, key string, fn func(*AttemptState)) (AttemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.entries[key]
+	if !ok {
+		state = &AttemptState{Key: key}
+		s.entries[key] = state
+	}
+	fn(state)
+
+	s.updates++
+	if s.updates%1000 == 0 {
+		s.sweep(time.Now())
+	}
+	return *state, nil
+}
+
+func (s *MemoryAttemptStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryAttemptStore) List(ctx context.Context, since time.Time) ([]AttemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := []AttemptState{}
+	for _, state := range s.entries {
+		if state.LastFailure.After(since) || state.BlockedUntil.After(time.Now()) {
+			states = append(states, *state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].LastFailure.After(states[j].LastFailure) })
+	return states, nil
+}
+
+func (s *MemoryAttemptStore) sweep(now time.Time) {
+	for key, state := range s.entries {
+		if now.Sub(state.LastFailure) > s.maxAge && now.After(state.BlockedUntil) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var testLoginPolicy = LoginPolicy{
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	MaxDelay:         time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  time.Hour,
+	FailureWindow:    15 * time.Minute,
+}
+
+func TestLoginPolicyBackoffAndLockout(t *testing.T) {
+	tests := []struct {
+		failures int
+		delay    time.Duration
+		locked   bool
+	}{
+		{1, 0, false},
+		{3, 0, false},
+		{4, time.Second, false},
+		{5, 2 * time.Second, false},
+		{6, 4 * time.Second, false},
+		{9, 32 * time.Second, false},
+		{10, time.Hour, true},
+	}
+	for _, tt := range tests {
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		state := AttemptState{}
+		for i := 0; i < tt.failures; i++ {
+			testLoginPolicy.recordFailure(&state, now)
+		}
+		var delay time.Duration
+		if !state.BlockedUntil.IsZero() {
+			delay = state.BlockedUntil.Sub(now)
+		}
+		if state.Failures != tt.failures || delay != tt.delay || state.Locked != tt.locked {
+			t.Errorf("after %d failures: failures %d, delay %s, locked %v; want delay %s, locked %v",
+				tt.failures, state.Failures, delay, state.Locked, tt.delay, tt.locked)
+		}
+	}
+}
+
+func TestLoginPolicyDelayIsClampedWithoutOverflow(t *testing.T) {
+	policy := testLoginPolicy
+	policy.LockoutThreshold = 0
+	policy.MaxDelay = 24 * time.Hour
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	state := AttemptState{}
+	var previous time.Duration
+	for i := 1; i <= 200; i++ {
+		policy.recordFailure(&state, now)
+		delay := state.BlockedUntil.Sub(now)
+		if i <= policy.FreeAttempts {
+			continue
+		}
+		if delay < previous || delay > policy.MaxDelay {
+			t.Fatalf("failure %d: delay %s after %s, max %s", i, delay, previous, policy.MaxDelay)
+		}
+		previous = delay
+	}
+	if previous != policy.MaxDelay {
+		t.Errorf("delay settled at %s, want %s", previous, policy.MaxDelay)
+	}
+}
+
+func TestLoginPolicyForgetsOldFailures(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("outside the window", func(t *testing.T) {
+		state := AttemptState{}
+		for i := 0; i < 5; i++ {
+			testLoginPolicy.recordFailure(&state, start)
+		}
+		testLoginPolicy.recordFailure(&state, start.Add(testLoginPolicy.FailureWindow+time.Second))
+		if state.Failures != 1 {
+			t.Errorf("failures = %d, want 1", state.Failures)
+		}
+	})
+	t.Run("inside the window", func(t *testing.T) {
+		state := AttemptState{}
+		for i := 0; i < 5; i++ {
+			testLoginPolicy.recordFailure(&state, start)
+		}
+		testLoginPolicy.recordFailure(&state, start.Add(testLoginPolicy.FailureWindow))
+		if state.Failures != 6 {
+			t.Errorf("failures = %d, want 6", state.Failures)
+		}
+	})
+	t.Run("after a lockout expires", func(t *testing.T) {
+		state := AttemptState{}
+		for i := 0; i < testLoginPolicy.LockoutThreshold; i++ {
+			testLoginPolicy.recordFailure(&state, start.Add(time.Duration(i)*time.Minute))
+		}
+		if !state.Locked {
+			t.Fatal("expected the key to be locked")
+		}
+		later := state.BlockedUntil.Add(time.Second)
+		testLoginPolicy.recordFailure(&state, later)
+		if state.Locked || state.Failures != 1 {
+			t.Errorf("locked %v, failures %d; want a fresh count", state.Locked, state.Failures)
+		}
+	})
+}
+
+func TestLoginLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := &LoginLimiter{Store: NewMemoryAttemptStore(time.Hour), Account: testLoginPolicy, IP: testLoginPolicy}
+	limiter.IP.FreeAttempts = 100
+	limiter.IP.LockoutThreshold = 0
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < testLoginPolicy.FreeAttempts; i++ {
+		if _, err := limiter.Failure(ctx, "Petr", "203.0.113.7", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if wait, _ := limiter.Allow(ctx, "petr", "203.0.113.7", now); wait != 0 {
+		t.Fatalf("wait %s within the free attempts", wait)
+	}
+	limiter.Failure(ctx, " PETR ", "198.51.100.1", now)
+	if wait, _ := limiter.Allow(ctx, "petr", "203.0.113.8", now); wait != time.Second {
+		t.Fatalf("wait %s, want the account to be delayed from any address", wait)
+	}
+
+	var locked []AttemptState
+	for i := testLoginPolicy.FreeAttempts + 1; i < testLoginPolicy.LockoutThreshold; i++ {
+		locked, _ = limiter.Failure(ctx, "petr", "203.0.113.7", now)
+	}
+	if len(locked) != 1 || locked[0].Key != AccountLimitKey("petr") {
+		t.Fatalf("locked %+v, want the account", locked)
+	}
+	if locked, _ = limiter.Failure(ctx, "petr", "203.0.113.7", now); len(locked) != 0 {
+		t.Errorf("an already locked key was reported again: %+v", locked)
+	}
+
+	if err := limiter.Success(ctx, "petr"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := limiter.Allow(ctx, "petr", "192.0.2.1", now); wait != 0 {
+		t.Errorf("wait %s after a success", wait)
+	}
+	if state, _ := limiter.Store.Get(ctx, IPLimitKey("203.0.113.7")); state.Failures == 0 {
+		t.Error("a success must not reset the IP history")
+	}
+}
+
+func TestMemoryAttemptStoreSweep(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryAttemptStore(time.Hour)
+	now := time.Now()
+	stale := func(s *AttemptState) { s.LastFailure = now.Add(-2 * time.Hour) }
+
+	store.Update(ctx, "idle", stale)
+	store.Update(ctx, "blocked", func(s *AttemptState) {
+		stale(s)
+		s.BlockedUntil = now.Add(time.Hour)
+	})
+	store.Update(ctx, "recent", func(s *AttemptState) { s.LastFailure = now })
+	store.sweep(now)
+
+	for key, kept := range map[string]bool{"idle": false, "blocked": true, "recent": true} {
+		if _, ok := store.entries[key]; ok != kept {
+			t.Errorf("%s kept = %v, want %v", key, ok, kept)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package internal
+
+// MergePatch applies an RFC 7396 JSON merge patch to target, both decoded
+// with encoding/json, and returns the result. Null members of an object
+// patch delete the member; any other patch value replaces target. target is
+// not modified.
+func MergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	result := map[string]interface{}{}
+	if targetObject, ok := target.(map[string]interface{}); ok {
+		for key, value := range targetObject {
+			result[key] = value
+		}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+		result[key] = MergePatch(result[key], value)
+	}
+	return result
+}
@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/petr-discover/config"
+	"github.com/petr-discover/internal/oidctest"
+	"golang.org/x/oauth2"
+)
+
+const testClientID = "petr-discover"
+
+func newTestProvider(t *testing.T) (*oidctest.Server, *OIDCProvider) {
+	t.Helper()
+	server, err := oidctest.NewServer(testClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	provider := NewOIDCProvider(config.OIDCProviderConfig{
+		Name:         "mock",
+		Issuer:       server.Issuer(),
+		ClientID:     testClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/v1/auth/mock/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	}, server.Client())
+	return server, provider
+}
+
+func TestOIDCDiscoveryAndAuthCodeURL(t *testing.T) {
+	server, provider := newTestProvider(t)
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "the-state", "the-nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != server.Issuer()+"/authorize" {
+		t.Errorf("authorization endpoint %s was not taken from discovery", got)
+	}
+	query := u.Query()
+	sum := sha256.Sum256([]byte(verifier))
+	for name, want := range map[string]string{
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"client_id":             testClientID,
+		"code_challenge_method": "S256",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(sum[:]),
+	} {
+		if query.Get(name) != want {
+			t.Errorf("%s = %q, want %q", name, query.Get(name), want)
+		}
+	}
+	if strings.Contains(authURL, verifier) {
+		t.Error("authorization URL leaks the PKCE verifier")
+	}
+}
+
+func TestOIDCDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	server, _ := newTestProvider(t)
+	provider := NewOIDCProvider(config.OIDCProviderConfig{
+		Name:     "mock",
+		Issuer:   server.Issuer() + "/other",
+		ClientID: testClientID,
+	}, server.Client())
+
+	if _, err := provider.AuthCodeURL(context.Background(), "s", "n", oauth2.GenerateVerifier()); err == nil {
+		t.Fatal("expected discovery to fail for a different issuer")
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	server, provider := newTestProvider(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := server.Authorize(authURL, jwt.MapClaims{
+		"sub":            "subject-1",
+		"email":          "petr@example.com",
+		"email_verified": "true",
+		"name":           "Petr",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := provider.Exchange(ctx, code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OIDCIdentity{Provider: "mock", Subject: "subject-1", Email: "petr@example.com", EmailVerified: true, Name: "Petr"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestOIDCExchangeRejectsWrongVerifier(t *testing.T) {
+	server, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", oauth2.GenerateVerifier())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := server.Authorize(authURL, jwt.MapClaims{"sub": "subject-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Exchange(ctx, code, oauth2.GenerateVerifier(), "nonce"); err == nil {
+		t.Fatal("expected the exchange to fail with another verifier")
+	}
+}
+
+func TestOIDCExchangeRejectsNonceMismatch(t *testing.T) {
+	server, provider := newTestProvider(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce-from-provider", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := server.Authorize(authURL, jwt.MapClaims{"sub": "subject-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Exchange(ctx, code, verifier, "nonce-from-cookie")
+	if err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("expected a nonce mismatch, got %v", err)
+	}
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	server, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		nonce  string
+		valid  bool
+	}{
+		{"valid", jwt.MapClaims{"sub": "s", "nonce": "n"}, "n", true},
+		{"missing nonce", jwt.MapClaims{"sub": "s"}, "n", false},
+		{"empty expected nonce", jwt.MapClaims{"sub": "s", "nonce": ""}, "", false},
+		{"other audience", jwt.MapClaims{"sub": "s", "nonce": "n", "aud": "someone-else"}, "n", false},
+		{"other authorized party", jwt.MapClaims{"sub": "s", "nonce": "n", "azp": "someone-else"}, "n", false},
+		{"other issuer", jwt.MapClaims{"sub": "s", "nonce": "n", "iss": "https://evil.example"}, "n", false},
+		{"expired", jwt.MapClaims{"sub": "s", "nonce": "n", "exp": time.Now().Add(-time.Minute).Unix()}, "n", false},
+		{"missing sub", jwt.MapClaims{"nonce": "n"}, "n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := server.IDToken(tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = provider.VerifyIDToken(ctx, token, tt.nonce)
+			if tt.valid && err != nil {
+				t.Fatalf("expected a valid token, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("expected the token to be rejected")
+			}
+		})
+	}
+}
+
+func TestOIDCVerifyIDTokenPicksUpRotatedKeys(t *testing.T) {
+	server, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	token, err := server.IDToken(jwt.MapClaims{"sub": "s", "nonce": "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(ctx, token, "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	token, err = server.IDToken(jwt.MapClaims{"sub": "s", "nonce": "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unknown kids only trigger a refetch once the cached set is old enough.
+	if _, err := provider.VerifyIDToken(ctx, token, "n"); err == nil {
+		t.Fatal("expected the new key to be unknown right after a fetch")
+	}
+	provider.mu.Lock()
+	provider.keysFetchedAt = time.Now().Add(-2 * oidcKeysRefetchMin)
+	provider.mu.Unlock()
+	if _, err := provider.VerifyIDToken(ctx, token, "n"); err != nil {
+		t.Fatalf("expected the rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestOIDCVerifyIDTokenRejectsForeignKey(t *testing.T) {
+	_, provider := newTestProvider(t)
+	other, err := oidctest.NewServer(testClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	token, err := other.IDToken(jwt.MapClaims{"sub": "s", "nonce": "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(context.Background(), token, "n"); err == nil {
+		t.Fatal("expected a token signed by another provider's key to be rejected")
+	}
+}
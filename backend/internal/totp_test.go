@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890".
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; ours are their last 6 digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("T=%d: code %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestTOTPCodeAcceptsLowercaseSecret(t *testing.T) {
+	upper, _ := TOTPCode(rfc6238Secret, 1)
+	lower, err := TOTPCode(strings.ToLower(rfc6238Secret), 1)
+	if err != nil || lower != upper {
+		t.Errorf("lowercase secret gave %q, %v; want %q", lower, err, upper)
+	}
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("expected an invalid secret to fail")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		offset int64
+		valid  bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, current+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := ValidateTOTP(rfc6238Secret, code, now)
+		if ok != tt.valid {
+			t.Errorf("offset %d: valid = %v, want %v", tt.offset, ok, tt.valid)
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("offset %d: step %d, want %d", tt.offset, step, current+tt.offset)
+		}
+	}
+
+	code, _ := TOTPCode(rfc6238Secret, current)
+	if _, ok := ValidateTOTP(rfc6238Secret, " "+code+"\n", now); !ok {
+		t.Error("expected surrounding whitespace to be ignored")
+	}
+	if _, ok := ValidateTOTP(rfc6238Secret, code[:5], now); ok {
+		t.Error("expected a short code to be rejected")
+	}
+}
+
+func TestRecoveryCodesRoundTripThroughNormalization(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q generated twice", code)
+		}
+		seen[code] = true
+
+		stored := NormalizeRecoveryCode(code)
+		if stored != code {
+			t.Errorf("generated code %q normalizes to %q", code, stored)
+		}
+		for _, typed := range []string{
+			strings.ToUpper(code),
+			"  " + code + "\n",
+			code[:5] + " - " + code[6:],
+			strings.Join(strings.Split(code, ""), " "),
+		} {
+			if NormalizeRecoveryCode(typed) != stored {
+				t.Errorf("typed %q does not match %q", typed, code)
+			}
+		}
+	}
+}
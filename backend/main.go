@@ -2,37 +2,74 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/petr-discover/cmd/database"
+	"github.com/petr-discover/cmd/graphsync"
+	"github.com/petr-discover/cmd/handlers"
 	"github.com/petr-discover/cmd/models"
 	"github.com/petr-discover/cmd/routes"
+	"github.com/petr-discover/internal"
 )
 
 var err error
 
 func main() {
+	flag.Parse()
+
 	database.DBMain, err = database.NewSQLDB("pgx")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	database.DBMain.LionMigrate(&models.Member{})
-
 	defer func() {
 		if err = database.DBMain.Close(); err != nil {
 			panic(err)
 		}
 		log.Println("Disconnected from SQL Database")
 	}()
+
+	if flag.Arg(0) == "migrate" {
+		if err = runMigrate(flag.Args()[1:]); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err = database.DBMain.Migrate(context.Background(), database.Migrations); err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.Arg(0) == "roles" {
+		if err = runRoles(flag.Args()[1:]); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if _, err = internal.AccessKeySet(); err != nil {
+		log.Fatal(err)
+	}
+
 	var cancel context.CancelFunc
 
 	database.Neo4jCtx, cancel = context.WithCancel(context.Background())
 	defer cancel()
 
 	database.Neo4jDriver, err = database.NewNeo4jDB(database.Neo4jCtx)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	defer func() {
 		if err = database.Neo4jDriver.Close(database.Neo4jCtx); err != nil {
@@ -41,6 +78,21 @@ func main() {
 		log.Println("Disconnected from Neo4j Database")
 	}()
 
+	if _, err = database.EnsureGraphSchema(database.Neo4jCtx, database.Neo4jDriver, database.GraphMigrations); err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.Arg(0) == "reconcile" {
+		if err = runReconcile(flag.Args()[1:]); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	go graphsync.NewWorker(database.DBMain, database.Neo4jDriver).Run(database.Neo4jCtx)
+	go handlers.RunFriendRequestSweeper(database.Neo4jCtx, database.Neo4jDriver)
+
 	// ctx := context.Background()
 	// client, err := storage.NewClient(ctx, option.WithCredentialsFile("auth.json"))
 	// if err != nil {
@@ -50,3 +102,111 @@ func main() {
 	r := routes.NewRouter(":8080")
 	http.ListenAndServe(":8080", r)
 }
+
+// runMigrate handles `main migrate [up|down [n]|status|dry-run|diff]`.
+func runMigrate(args []string) error {
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		return database.DBMain.Migrate(ctx, database.Migrations)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+			steps = n
+		}
+		return database.DBMain.Rollback(ctx, database.Migrations, steps)
+	case "status":
+		status, err := database.DBMain.MigrationStatus(ctx, database.Migrations)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%d_%s\t%s\n", s.Version, s.Name, state)
+		}
+		return nil
+	case "dry-run":
+		return database.DBMain.MigrateDryRun(ctx, os.Stdout, database.Migrations)
+	case "diff":
+		statements, err := database.DBMain.DiffModel(ctx, &models.Member{})
+		if err != nil {
+			return err
+		}
+		if len(statements) == 0 {
+			fmt.Println("-- member table matches models.Member")
+		}
+		for _, s := range statements {
+			fmt.Println(s)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command: %s", command)
+}
+
+// runRoles handles `main roles <username> [role...]`, which prints or
+// replaces a member's roles. It is how the first admin gets appointed.
+func runRoles(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: roles <username> [role...]")
+	}
+	ctx := context.Background()
+	id, current, _, found, err := database.DBMain.MemberAccess(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("user %s not found", args[0])
+	}
+	if len(args) == 1 {
+		fmt.Println(strings.Join(current, " "))
+		return nil
+	}
+
+	roles := []string{handlers.RoleUser}
+	for _, role := range args[1:] {
+		switch role {
+		case handlers.RoleUser:
+		case handlers.RoleModerator, handlers.RoleAdmin:
+			roles = append(roles, role)
+		default:
+			return fmt.Errorf("unknown role %s", role)
+		}
+	}
+	if _, err := database.DBMain.SetMemberRoles(ctx, id, roles); err != nil {
+		return err
+	}
+	return database.DBMain.RecordAudit(ctx, database.AuditEntry{
+		Actor:      "cli",
+		Action:     "members.roles",
+		TargetType: "member",
+		TargetID:   strconv.FormatInt(id, 10),
+		Details:    map[string]interface{}{"username": args[0], "from": current, "to": roles},
+	})
+}
+
+// runReconcile handles `main reconcile [fix]`.
+func runReconcile(args []string) error {
+	fix := len(args) > 0 && args[0] == "fix"
+	report, err := graphsync.Reconcile(database.Neo4jCtx, database.DBMain, database.Neo4jDriver, fix)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}